@@ -6,17 +6,64 @@ const DefaultContainerName string = "emqx"
 
 const DefaultBootstrapAPIKey string = "emqx-operator-controller"
 
+const (
+	// update strategy types
+	UpdateStrategyRecreate      string = "Recreate"
+	UpdateStrategyRollingUpdate string = "RollingUpdate"
+)
+
+const (
+	// core update modes
+	CoreUpdateModeBlueGreen string = "BlueGreen"
+	CoreUpdateModeInPlace   string = "InPlace"
+)
+
+const (
+	// analysis failure policies
+	AnalysisFailurePolicyPause string = "Pause"
+	AnalysisFailurePolicyAbort string = "Abort"
+)
+
 const (
 	// labels
 	LabelsInstanceKey        string = "apps.emqx.io/instance"   // my-emqx
 	LabelsManagedByKey       string = "apps.emqx.io/managed-by" // emqx-operator
 	LabelsDBRoleKey          string = "apps.emqx.io/db-role"    // core, replicant
 	LabelsPodTemplateHashKey string = "apps.emqx.io/pod-template-hash"
+	LabelsListenerServiceKey string = "apps.emqx.io/listener-service" // .spec.listeners[].service.name
+	LabelsTLSCertificateKey  string = "apps.emqx.io/tls-certificate"  // .spec.tls[].name
+	LabelsUpdateHookKey      string = "apps.emqx.io/update-hook"      // pre-switch, post-switch
 )
 
 const (
 	// annotations
 	AnnotationsLastEMQXConfigKey string = "apps.emqx.io/last-emqx-configuration"
+	// The hash of the config sources and the secrets of the placeholders when the EMQX config was last applied
+	AnnotationsLastEMQXConfigSourcesHashKey string = "apps.emqx.io/last-emqx-configuration-sources-hash"
+	// The EMQX spec that generated a StatefulSet / ReplicaSet, used to rollback to it
+	AnnotationsRevisionSpecKey string = "apps.emqx.io/revision-spec"
+	// The hash of the EMQX config when a StatefulSet / ReplicaSet was created
+	AnnotationsRevisionConfigHashKey string = "apps.emqx.io/revision-config-hash"
+	// The hash of the readonly EMQX config, set in the pod template to roll the EMQX nodes when it's changed
+	AnnotationsReadOnlyConfigHashKey string = "apps.emqx.io/readonly-config-hash"
+	// The hash of the config of the EMQX nodes role, set in the pod template to roll the EMQX nodes when it's changed
+	AnnotationsRoleConfigHashKey string = "apps.emqx.io/role-config-hash"
+	// The hash of the TLS certificate secrets when the listeners were last reloaded
+	AnnotationsTLSCertificatesHashKey string = "apps.emqx.io/tls-certificates-hash"
+	// The time when the TLS certificate secrets were changed, the listeners are reloaded after the secrets are synced to the EMQX nodes
+	AnnotationsTLSCertificatesChangedAtKey string = "apps.emqx.io/tls-certificates-changed-at"
+	// Set on the EMQX custom resource to rotate the API key of the operator, it's removed once the API key is rotated
+	AnnotationsRotateBootstrapAPIKeyKey string = "apps.emqx.io/rotate-bootstrap-api-key"
+	// The last time the API key of the operator was rotated
+	AnnotationsBootstrapAPIKeyRotatedAtKey string = "apps.emqx.io/bootstrap-api-key-rotated-at"
+	// The API key of the operator in the bootstrap API key secret, it's not set before the API key is rotated
+	AnnotationsBootstrapAPIKeyKey string = "apps.emqx.io/bootstrap-api-key"
+	// Set on the EMQX custom resource to rotate the node cookie to a random one, it's removed once the node cookie is rotated
+	AnnotationsRotateNodeCookieKey string = "apps.emqx.io/rotate-node-cookie"
+	// The hash of the rotated node cookie, set on the EMQX custom resource and the pod template to roll the EMQX nodes by the blue-green update
+	AnnotationsNodeCookieHashKey string = "apps.emqx.io/node-cookie-hash"
+	// The last time the clients of an EMQX open source node were kicked
+	AnnotationsLastDrainTimeKey string = "apps.emqx.io/last-drain-time"
 )
 
 const (
@@ -17,6 +17,7 @@ limitations under the License.
 package v2beta1
 
 import (
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -67,9 +68,19 @@ type EMQXSpec struct {
 	// Cannot be updated.
 	BootstrapAPIKeys []BootstrapAPIKey `json:"bootstrapAPIKeys,omitempty"`
 
+	// BootstrapAPIKeyRotation is the config to rotate the API key used by the operator to request the EMQX management API
+	BootstrapAPIKeyRotation *BootstrapAPIKeyRotation `json:"bootstrapAPIKeyRotation,omitempty"`
+
 	// EMQX config
 	Config Config `json:"config,omitempty"`
 
+	// ManagementAPI is the config of the operator to request the EMQX management API
+	ManagementAPI *ManagementAPI `json:"managementAPI,omitempty"`
+
+	// NetworkPolicy is the config of the NetworkPolicy generated for the EMQX cluster.
+	// If it's set, the ingress traffic of the EMQX nodes is restricted by the NetworkPolicy.
+	NetworkPolicy *NetworkPolicy `json:"networkPolicy,omitempty"`
+
 	//+kubebuilder:default:="cluster.local"
 	ClusterDomain string `json:"clusterDomain,omitempty"`
 
@@ -83,6 +94,11 @@ type EMQXSpec struct {
 	//+kubebuilder:default={type:Recreate,initialDelaySeconds:10,evacuationStrategy:{waitTakeover:10,connEvictRate:1000,sessEvictRate:1000}}
 	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
 
+	// RollbackTo is the revision to rollback the EMQX cluster to.
+	// The revision must be one of the status.revisions, the retained statefulSet or replicaSet of it will be scaled up again.
+	// This field is cleared once the rollback has been applied to the EMQX spec.
+	RollbackTo *RollbackConfig `json:"rollbackTo,omitempty"`
+
 	// CoreTemplate is the object that describes the EMQX core node that will be created
 	//+kubebuilder:default={spec:{replicas:1}}
 	CoreTemplate EMQXCoreTemplate `json:"coreTemplate,omitempty"`
@@ -97,6 +113,126 @@ type EMQXSpec struct {
 	// If the EMQX replicant node exist, this service will selector the EMQX replicant node
 	// Else this service will selector EMQX core node
 	ListenersServiceTemplate *ServiceTemplate `json:"listenersServiceTemplate,omitempty"`
+
+	// Listeners of the EMQX cluster.
+	// If it's set, the operator renders the listeners into the EMQX config, the container ports and the service ports,
+	// and the default listeners of EMQX that are not in it are disabled.
+	// If it's not set, the listeners are parsed from the EMQX config.
+	// +listType=map
+	// +listMapKey=type
+	// +listMapKey=name
+	Listeners []Listener `json:"listeners,omitempty"`
+
+	// TLS certificates issued by cert-manager for the listeners.
+	// The operator creates a cert-manager Certificate for each of them, mounts the issued secret to the EMQX nodes,
+	// sets the ssl_options of the listeners, and reloads the listeners when the secret is renewed.
+	// +listType=map
+	// +listMapKey=name
+	TLS []TLSCertificate `json:"tls,omitempty"`
+}
+
+type TLSCertificate struct {
+	// Name of the TLS certificate, the Certificate and the Secret are named "<EMQX name>-<name>-tls".
+	// +kubebuilder:validation:Pattern:=`^[a-z\d]([-a-z\d]*[a-z\d])?$`
+	// +kubebuilder:validation:MaxLength=32
+	Name string `json:"name"`
+	// IssuerRef is the reference to the cert-manager Issuer or ClusterIssuer.
+	IssuerRef TLSIssuerReference `json:"issuerRef"`
+	// DNSNames of the certificate.
+	// Defaults to the DNS names of the listeners service.
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// Listeners that use the certificate, in the format of "<type>-<name>", like "ssl-default".
+	// Only the ssl, wss and quic listeners are supported.
+	Listeners []string `json:"listeners,omitempty"`
+	// ClientCASecretRef is the reference to the secret of the CA bundle to verify the client certificates.
+	// If it's set, the listeners require the client certificates, the "ca.crt" key of the secret is used.
+	ClientCASecretRef *corev1.LocalObjectReference `json:"clientCASecretRef,omitempty"`
+}
+
+type TLSIssuerReference struct {
+	// Name of the issuer.
+	Name string `json:"name"`
+	// Kind of the issuer.
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default:="Issuer"
+	Kind string `json:"kind,omitempty"`
+	// Group of the issuer.
+	// +kubebuilder:default:="cert-manager.io"
+	Group string `json:"group,omitempty"`
+}
+
+type Listener struct {
+	// Type of the listener.
+	// +kubebuilder:validation:Enum=tcp;ssl;ws;wss;quic
+	Type string `json:"type"`
+	// Name of the listener.
+	// The "<type>-<name>" is used as the container port name, so it must be no more than 15 characters.
+	// +kubebuilder:validation:Pattern:=`^[a-z\d]([-a-z\d]*[a-z\d])?$`
+	// +kubebuilder:default:="default"
+	Name string `json:"name"`
+	// Port of the listener.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// Enable the listener.
+	// This is a pointer to distinguish between `false` and not specified.
+	//+kubebuilder:default:=true
+	Enabled *bool `json:"enabled,omitempty"`
+	// TLSSecretRef is the reference to the kubernetes.io/tls type secret, only for the ssl, wss and quic listeners.
+	// The secret is mounted to the EMQX nodes, and the tls.crt and tls.key are used as the certfile and keyfile of the listener.
+	TLSSecretRef *corev1.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+	// Service describes how the listener is exposed.
+	Service *ListenerService `json:"service,omitempty"`
+}
+
+type ListenerService struct {
+	// Expose the listener by the service.
+	// This is a pointer to distinguish between `false` and not specified.
+	//+kubebuilder:default:=true
+	Enabled *bool `json:"enabled,omitempty"`
+	// Name of the service that exposes the listener.
+	// If it's empty, the listener is exposed by the listeners service,
+	// else the operator creates a service named "<EMQX name>-<name>" for the listener.
+	// The name can not be "headless", "dashboard" or "listeners", which are used by the other services of EMQX.
+	Name string `json:"name,omitempty"`
+	// Type of the service, only for the service that is not the listeners service.
+	//+kubebuilder:default:="ClusterIP"
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// NodePort of the service port, only for the NodePort or LoadBalancer type service.
+	NodePort int32 `json:"nodePort,omitempty"`
+	// Annotations are added to the service.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ManagementAPI struct {
+	// TLS config to request the EMQX management API by the dashboard https listener.
+	// If it's set, the operator requests the EMQX management API by the dashboard https listener when it's enabled,
+	// else the dashboard http listener is preferred.
+	TLS *ManagementAPITLS `json:"tls,omitempty"`
+}
+
+type ManagementAPITLS struct {
+	// CASecretRef is the reference to the secret of the CA bundle to verify the certificate of the dashboard https listener.
+	// The "ca.crt" key of the secret is used. If it's not set, the system CA bundle is used.
+	CASecretRef *corev1.LocalObjectReference `json:"caSecretRef,omitempty"`
+	// ClientCertSecretRef is the reference to the kubernetes.io/tls type secret of the client certificate,
+	// it's used when the dashboard https listener verifies the client certificates.
+	ClientCertSecretRef *corev1.LocalObjectReference `json:"clientCertSecretRef,omitempty"`
+	// ServerName is used to verify the hostname of the certificate of the dashboard https listener,
+	// because the operator requests the EMQX nodes by the pod IP.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+type NetworkPolicy struct {
+	// DashboardCIDRs are the CIDRs allowed to access the dashboard and management API ports of the EMQX nodes,
+	// besides the pods in the namespace of the operator.
+	DashboardCIDRs []string `json:"dashboardCIDRs,omitempty"`
+}
+
+type BootstrapAPIKeyRotation struct {
+	// Interval is the interval to rotate the API key of the operator, like "2160h".
+	// The API key can also be rotated on demand by the "apps.emqx.io/rotate-bootstrap-api-key" annotation.
+	Interval *metav1.Duration `json:"interval,omitempty"`
 }
 
 type BootstrapAPIKey struct {
@@ -124,19 +260,166 @@ type Config struct {
 	//+kubebuilder:default=Merge
 	Mode string `json:"mode,omitempty"`
 	// EMQX config, HOCON format, like etc/emqx.conf file
+	// The values of Secrets can be referenced by the `${secret:name/key}` placeholders in quoted strings,
+	// like `password = "${secret:db-creds/password}"`, they are resolved in the pushed config and the generated ConfigMap only.
+	// The `node.cookie` of the EMQX config, including the config sources, is written to the node cookie secret.
+	// When it's changed, or the "apps.emqx.io/rotate-node-cookie" annotation is set to rotate to a random one,
+	// the EMQX nodes are rolled by the blue-green update, and the new EMQX nodes accept the previous node cookie of the old EMQX nodes
+	// until the old EMQX nodes are removed. The progress is reported in the NodeCookieRotating condition.
 	Data string `json:"data,omitempty"`
+	// HOCON config fragments from ConfigMaps and Secrets, they are merged in order,
+	// and then the EMQX config in .spec.config.data is merged on top of them.
+	// The dashboard listeners are only read from .spec.config.data.
+	Sources []ConfigSource `json:"sources,omitempty"`
+	// Re-apply the EMQX config when the config of the running EMQX cluster drifts from it,
+	// for example the config is changed by the EMQX dashboard or API.
+	// The drift is always reported in the ConfigDrifted condition.
+	Enforce bool `json:"enforce,omitempty"`
+	// Roll the EMQX nodes when the readonly config in .spec.config.data is changed, the readonly config is
+	// `node`, `cluster`, `rpc`, and `dashboard` before EMQX 5.7.0, it can not be updated in the running EMQX cluster.
+	// Enabling it rolls the EMQX nodes once.
+	RollOnReadOnlyChange bool `json:"rollOnReadOnlyChange,omitempty"`
+}
+
+type ConfigSource struct {
+	// Selects a key of a ConfigMap in the namespace of the EMQX custom resource.
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// Selects a key of a Secret in the namespace of the EMQX custom resource.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+type RollbackConfig struct {
+	// The pod template hash of the revision to rollback to.
+	//+kubebuilder:validation:MinLength=1
+	Revision string `json:"revision"`
 }
 
 type UpdateStrategy struct {
-	//+kubebuilder:validation:Enum=Recreate
+	// Type of the update strategy.
+	// Recreate creates a whole new StatefulSet / ReplicaSet for every pod template change, then evacuates and removes the old nodes.
+	// RollingUpdate works like Recreate for EMQX core nodes, but replaces EMQX replicant nodes a few at a time, see RollingUpdate.
+	//+kubebuilder:validation:Enum=Recreate;RollingUpdate
 	//+kubebuilder:default=Recreate
 	Type string `json:"type,omitempty"`
+	// Rolling update config params. Present only if Type = RollingUpdate.
+	RollingUpdate *RollingUpdateStrategy `json:"rollingUpdate,omitempty"`
+	// Update mode of EMQX core nodes.
+	// BlueGreen creates a new StatefulSet with new PVCs for every pod template change.
+	// InPlace keeps one StatefulSet and updates the EMQX core nodes one by one with the partition of the StatefulSet,
+	// so the existing PVCs are reused. The next core node is updated only when the previous one is running in the EMQX cluster.
+	// It cannot be updated from BlueGreen to InPlace.
+	//+kubebuilder:validation:Enum=BlueGreen;InPlace
+	//+kubebuilder:default=BlueGreen
+	CoreUpdateMode string `json:"coreUpdateMode,omitempty"`
+	// The maximum time in seconds for the new EMQX core nodes or replicant nodes to become ready.
+	// If they are not ready in time, the EMQX spec is restored to the current revision,
+	// and the RollbackTriggered condition is set.
+	// The deadline is suspended while the update is paused, and restarts when the update is resumed.
+	// Not set means no deadline.
+	//+kubebuilder:validation:Minimum=1
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// Paused stops the update in progress: the old EMQX nodes are neither evacuated nor scaled down,
+	// until Paused is set back to false. The status of the EMQX cluster keeps being updated, and the Paused condition is set.
+	// The progress deadline restarts when the update is resumed.
+	Paused bool `json:"paused,omitempty"`
+	// Canary splits the evacuation of the old EMQX nodes into steps, and pauses after each step.
+	// It works on EMQX replicant nodes, or EMQX core nodes if there are no replicant nodes.
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+	// Analysis compares the metrics of the new EMQX nodes with the old EMQX nodes before each old EMQX node is removed.
+	// It does not work in the InPlace core update mode.
+	Analysis *AnalysisStrategy `json:"analysis,omitempty"`
+	// Hooks are Jobs that run at the well-defined points of the update.
+	Hooks *UpdateHooks `json:"hooks,omitempty"`
 	// Number of seconds before evacuation connection start.
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
 	// Number of seconds before evacuation connection timeout.
 	EvacuationStrategy EvacuationStrategy `json:"evacuationStrategy,omitempty"`
 }
 
+type AnalysisStrategy struct {
+	// What to do when the new EMQX nodes regress.
+	// Pause holds the old EMQX nodes until the metrics of the new EMQX nodes recover.
+	// Abort restores the EMQX spec to the current revision, like ProgressDeadlineSeconds does.
+	//+kubebuilder:validation:Enum=Pause;Abort
+	//+kubebuilder:default=Pause
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// Metrics from api/v5/metrics and api/v5/stats to compare.
+	Metrics []AnalysisMetric `json:"metrics,omitempty"`
+	// The maximum number of activated alarms from api/v5/alarms on each new EMQX node.
+	// Not set means the alarms are not checked.
+	//+kubebuilder:validation:Minimum=0
+	MaxAlarms *int32 `json:"maxAlarms,omitempty"`
+}
+
+type AnalysisMetric struct {
+	// Name of the metric in api/v5/metrics or api/v5/stats, example: "authentication.failure", "messages.dropped".
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// The metric is divided by this metric to get a rate, example: "messages.received" for "messages.dropped".
+	// Not set means the value of the metric is compared.
+	DividedBy string `json:"dividedBy,omitempty"`
+	// The new EMQX nodes regress when their average value is greater than the average value of the old EMQX nodes
+	// by more than AbsThreshold, and by more than RelThreshold times.
+	// Float values are defined as string, like RelConnThreshold in Rebalance.
+	// Defaults to "0".
+	//+kubebuilder:default:="0"
+	AbsThreshold string `json:"absThreshold,omitempty"`
+	// The value must be greater than "1.0"
+	// Defaults to "1.1".
+	//+kubebuilder:default:="1.1"
+	RelThreshold string `json:"relThreshold,omitempty"`
+}
+
+type UpdateHooks struct {
+	// PreSwitch Job runs after the new EMQX nodes are ready, before the old EMQX nodes are evacuated and scaled down.
+	// The update is blocked until the Job succeeds, and the PreSwitchHookFailed condition is set if the Job fails.
+	// Change the Job template, or delete the failed Job, to run it again.
+	// The schema of the Job template is not validated in the CRD, to keep the CRD small.
+	//+kubebuilder:validation:Schemaless
+	//+kubebuilder:validation:Type=object
+	//+kubebuilder:pruning:PreserveUnknownFields
+	PreSwitch *batchv1.JobTemplateSpec `json:"preSwitch,omitempty"`
+	// PostSwitch Job runs after the old EMQX nodes are scaled down to zero.
+	//+kubebuilder:validation:Schemaless
+	//+kubebuilder:validation:Type=object
+	//+kubebuilder:pruning:PreserveUnknownFields
+	PostSwitch *batchv1.JobTemplateSpec `json:"postSwitch,omitempty"`
+}
+
+type CanaryStrategy struct {
+	// Steps are executed in order, until all the old EMQX nodes are removed.
+	Steps []CanaryStep `json:"steps,omitempty"`
+}
+
+type CanaryStep struct {
+	// The number of old EMQX nodes which have been moved to the new revision when this step is reached.
+	// Value can be an absolute number (ex: 5) or a percentage of desired replicas (ex: 10%).
+	// Absolute number is calculated from percentage by rounding up.
+	// +kubebuilder:validation:XIntOrString
+	Replicas intstr.IntOrString `json:"replicas"`
+	// Number of seconds to pause after this step is reached, before moving on to the next step.
+	//+kubebuilder:validation:Minimum=0
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+}
+
+type RollingUpdateStrategy struct {
+	// The maximum number of EMQX replicant nodes that can be scheduled above the desired number of replicas.
+	// Value can be an absolute number (ex: 5) or a percentage of desired replicas (ex: 10%).
+	// Absolute number is calculated from percentage by rounding up.
+	// Defaults to 25%.
+	// +kubebuilder:validation:XIntOrString
+	//+kubebuilder:default="25%"
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// The maximum number of EMQX replicant nodes that can be unavailable during the update.
+	// Value can be an absolute number (ex: 5) or a percentage of desired replicas (ex: 10%).
+	// Absolute number is calculated from percentage by rounding down.
+	// This can not be 0 if MaxSurge is 0.
+	// Defaults to 0.
+	// +kubebuilder:validation:XIntOrString
+	//+kubebuilder:default=0
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
 type EvacuationStrategy struct {
 	//+kubebuilder:validation:Minimum=0
 	WaitTakeover int32 `json:"waitTakeover,omitempty"`
@@ -148,12 +431,27 @@ type EvacuationStrategy struct {
 	//+kubebuilder:validation:Minimum=1
 	//+kubebuilder:default=1000
 	SessEvictRate int32 `json:"sessEvictRate,omitempty"`
+	// Just work in EMQX open source.
+	// Kick the clients of the EMQX node through the client management API before the node is removed,
+	// so the clients reconnect to the other EMQX nodes gradually.
+	// Not set means the EMQX node is removed with all its clients after WaitTakeover.
+	OpenSourceDrain *OpenSourceDrainStrategy `json:"openSourceDrain,omitempty"`
+}
+
+type OpenSourceDrainStrategy struct {
+	// Number of clients kicked per second.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:default=100
+	ConnKickRate int32 `json:"connKickRate,omitempty"`
 }
 
 type EMQXCoreTemplate struct {
 	// Standard object's metadata.
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
 	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Config of the EMQX core nodes, it's merged on top of .spec.config.data for the EMQX core nodes only.
+	// It's rendered into a separate ConfigMap, and the EMQX core nodes are rolled when it's changed.
+	Config *RoleConfig `json:"config,omitempty"`
 	// Specification of the desired behavior of the EMQX core node.
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
 	Spec EMQXCoreTemplateSpec `json:"spec,omitempty"`
@@ -163,6 +461,9 @@ type EMQXReplicantTemplate struct {
 	// Standard object's metadata.
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
 	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Config of the EMQX replicant nodes, it's merged on top of .spec.config.data for the EMQX replicant nodes only.
+	// It's rendered into a separate ConfigMap, and the EMQX replicant nodes are rolled when it's changed.
+	Config *RoleConfig `json:"config,omitempty"`
 	// Specification of the desired behavior of the EMQX replicant node.
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
 	// Controller tools does not support more complex validations (oneOf/anyOf/allOf/etc), so use validation rule instead. https://github.com/kubernetes-sigs/controller-tools/issues/461#issuecomment-1982741599
@@ -170,6 +471,11 @@ type EMQXReplicantTemplate struct {
 	Spec EMQXReplicantTemplateSpec `json:"spec,omitempty"`
 }
 
+type RoleConfig struct {
+	// EMQX config, HOCON format, like etc/emqx.conf file
+	Data string `json:"data,omitempty"`
+}
+
 type EMQXCoreTemplateSpec struct {
 	// Controller tools does not support more complex validations (oneOf/anyOf/allOf/etc), so use validation rule instead. https://github.com/kubernetes-sigs/controller-tools/issues/461#issuecomment-1982741599
 	// +kubebuilder:validation:XValidation:rule="has(self.minAvailable) && has(self.maxUnavailable) ? false : true",message="minAvailable cannot be set when maxUnavailable is specified. These fields are mutually exclusive in PodDisruptionBudget."
@@ -0,0 +1,402 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2beta1
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	emperror "emperror.dev/errors"
+	semver "github.com/Masterminds/semver/v3"
+	hocon "github.com/rory-z/go-hocon"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var emqxlog = logf.Log.WithName("emqx-resource")
+
+func (r *EMQX) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-apps-emqx-io-v2beta1-emqx,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.emqx.io,resources=emqxes,verbs=create;update,versions=v2beta1,name=mutating.emqx.emqx.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &EMQX{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *EMQX) Default() {
+	emqxlog.Info("default", "name", r.Name)
+
+	// The CRD defaults of the nested fields only work when the parent field is set
+	if r.Spec.UpdateStrategy.Type == UpdateStrategyRollingUpdate && r.Spec.UpdateStrategy.RollingUpdate == nil {
+		r.Spec.UpdateStrategy.RollingUpdate = &RollingUpdateStrategy{}
+	}
+	if rollingUpdate := r.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil {
+		if rollingUpdate.MaxSurge == nil {
+			rollingUpdate.MaxSurge = &intstr.IntOrString{Type: intstr.String, StrVal: "25%"}
+		}
+		if rollingUpdate.MaxUnavailable == nil {
+			rollingUpdate.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 0}
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-apps-emqx-io-v2beta1-emqx,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.emqx.io,resources=emqxes,verbs=create;update,versions=v2beta1,name=validator.emqx.emqx.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &EMQX{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *EMQX) ValidateCreate() (admission.Warnings, error) {
+	emqxlog.Info("validate create", "name", r.Name)
+
+	warnings, err := r.validate()
+	if err != nil {
+		emqxlog.Error(err, "validate create failed")
+		return nil, err
+	}
+	if conflicts := GetEMQXEnvConfigConflicts(r, r.Spec.Config.Data); len(conflicts) > 0 {
+		err := fmt.Errorf("the EMQX_ environment variables conflict with the EMQX config: %s", strings.Join(conflicts, ", "))
+		emqxlog.Error(err, "validate create failed")
+		return nil, err
+	}
+	if len(getNodeCookieEnvs(r)) > 0 {
+		err := errors.New(nodeCookieEnvError)
+		emqxlog.Error(err, "validate create failed")
+		return nil, err
+	}
+	return warnings, nil
+}
+
+func (r *EMQX) validate() (admission.Warnings, error) {
+	warnings := admission.Warnings{}
+	for _, cb := range []func(*EMQX) (admission.Warnings, error){
+		validateEMQXConfig,
+		validateEMQXListeners,
+		validateEMQXTLS,
+		validateBootstrapAPIKeyRotation,
+		validateNetworkPolicy,
+		validateEMQXReplicas,
+	} {
+		w, err := cb(r)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w...)
+	}
+	return warnings, nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *EMQX) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	emqxlog.Info("validate update", "name", r.Name)
+	oldEMQX := old.(*EMQX)
+
+	if !reflect.DeepEqual(r.Spec.BootstrapAPIKeys, oldEMQX.Spec.BootstrapAPIKeys) {
+		err := errors.New(`the field ".spec.bootstrapAPIKeys" cannot be updated`)
+		emqxlog.Error(err, "validate update failed")
+		return nil, err
+	}
+	// The in-place updated statefulSet is not named by the pod template hash, it can not take over the pods and PVCs
+	// of the blue-green updated statefulSet, and its selector overlaps with the pods of the blue-green updated statefulSet
+	if r.Spec.UpdateStrategy.CoreUpdateMode == CoreUpdateModeInPlace && oldEMQX.Spec.UpdateStrategy.CoreUpdateMode != CoreUpdateModeInPlace {
+		err := errors.New(`the field ".spec.updateStrategy.coreUpdateMode" cannot be updated from BlueGreen to InPlace`)
+		emqxlog.Error(err, "validate update failed")
+		return nil, err
+	}
+
+	warnings, err := r.validate()
+	if err != nil {
+		emqxlog.Error(err, "validate update failed")
+		return nil, err
+	}
+	// The EMQX nodes with different cookies cannot form a cluster, so the new EMQX nodes of the blue-green update
+	// cannot join the EMQX cluster if the node cookie is changed by the environment variable
+	if !reflect.DeepEqual(getNodeCookieEnvs(r), getNodeCookieEnvs(oldEMQX)) {
+		err := errors.New(nodeCookieEnvError)
+		emqxlog.Error(err, "validate update failed")
+		return nil, err
+	}
+	if cookie := GetNodeCookie(r.Spec.Config.Data); cookie != "" && cookie != GetNodeCookie(oldEMQX.Spec.Config.Data) {
+		if r.Spec.UpdateStrategy.CoreUpdateMode == CoreUpdateModeInPlace {
+			warnings = append(warnings, `the node cookie cannot be rotated to the "node.cookie" in ".spec.config.data" by the in-place update`)
+		} else {
+			warnings = append(warnings, `the node cookie will be rotated to the "node.cookie" in ".spec.config.data", the EMQX nodes will be rolled by the blue-green update`)
+		}
+	}
+	_, rotate := r.Annotations[AnnotationsRotateNodeCookieKey]
+	if _, rotated := oldEMQX.Annotations[AnnotationsRotateNodeCookieKey]; rotate && !rotated && GetNodeCookie(r.Spec.Config.Data) != "" {
+		warnings = append(warnings, `the node cookie is set by the "node.cookie" in ".spec.config.data", it will not be rotated to a random one by the annotation`)
+	}
+	// Only reject the new conflicts, so the EMQX custom resources with the existing conflicts can still be updated
+	oldConflicts := GetEMQXEnvConfigConflicts(oldEMQX, oldEMQX.Spec.Config.Data)
+	for _, conflict := range GetEMQXEnvConfigConflicts(r, r.Spec.Config.Data) {
+		if !slices.Contains(oldConflicts, conflict) {
+			err := fmt.Errorf("the EMQX_ environment variables conflict with the EMQX config: %s", conflict)
+			emqxlog.Error(err, "validate update failed")
+			return nil, err
+		}
+		warnings = append(warnings, fmt.Sprintf("the EMQX config is overridden by the EMQX_ environment variables: %s", conflict))
+	}
+	if r.Spec.Config.Data != oldEMQX.Spec.Config.Data {
+		warnings = append(warnings, validateReadOnlyConfig(r)...)
+	}
+	return warnings, nil
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *EMQX) ValidateDelete() (admission.Warnings, error) {
+	emqxlog.Info("validate delete", "name", r.Name)
+
+	return nil, nil
+}
+
+func validateEMQXConfig(r *EMQX) (admission.Warnings, error) {
+	if _, err := hocon.ParseString(r.Spec.Config.Data); err != nil {
+		return nil, emperror.Wrap(err, `the field ".spec.config.data" is not a valid HOCON config`)
+	}
+	if r.Spec.CoreTemplate.Config != nil {
+		if _, err := hocon.ParseString(r.Spec.CoreTemplate.Config.Data); err != nil {
+			return nil, emperror.Wrap(err, `the field ".spec.coreTemplate.config.data" is not a valid HOCON config`)
+		}
+	}
+	if r.Spec.ReplicantTemplate != nil && r.Spec.ReplicantTemplate.Config != nil {
+		if _, err := hocon.ParseString(r.Spec.ReplicantTemplate.Config.Data); err != nil {
+			return nil, emperror.Wrap(err, `the field ".spec.replicantTemplate.config.data" is not a valid HOCON config`)
+		}
+	}
+
+	dashboardPorts, _ := GetDashboardServicePort(r.Spec.Config.Data)
+	listenerPorts := []corev1.ServicePort{}
+	if len(r.Spec.Listeners) > 0 {
+		for _, listener := range r.Spec.Listeners {
+			if IsListenerEnabled(listener) {
+				listenerPorts = append(listenerPorts, GetListenerServicePort(listener))
+			}
+		}
+	} else {
+		ports, err := GetListenersServicePorts(r.Spec.Config.Data)
+		if err != nil {
+			return nil, emperror.Wrap(err, `failed to get the listeners of ".spec.config.data"`)
+		}
+		listenerPorts = ports
+	}
+
+	names := map[string]string{}
+	for _, port := range append(dashboardPorts, listenerPorts...) {
+		if port.Port == 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", port.Protocol, port.Port)
+		if name, ok := names[key]; ok {
+			return nil, fmt.Errorf(`the port %d of "%s" collides with "%s"`, port.Port, port.Name, name)
+		}
+		names[key] = port.Name
+	}
+	return nil, nil
+}
+
+func validateEMQXListeners(r *EMQX) (admission.Warnings, error) {
+	names := map[string]struct{}{}
+	for i, listener := range r.Spec.Listeners {
+		path := fmt.Sprintf(".spec.listeners[%d]", i)
+		name := GetListenerServicePort(listener).Name
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf(`the listener "%s" in "%s" is duplicated`, name, path)
+		}
+		names[name] = struct{}{}
+		if errs := validation.IsValidPortName(name); len(errs) > 0 {
+			return nil, fmt.Errorf(`the listener "%s" in "%s" is not a valid port name: %s`, name, path, strings.Join(errs, ", "))
+		}
+		if listener.TLSSecretRef != nil && listener.Type != "ssl" && listener.Type != "wss" && listener.Type != "quic" {
+			return nil, fmt.Errorf(`the field "%s.tlsSecretRef" is only supported by the ssl, wss and quic listeners`, path)
+		}
+		if listener.Service != nil && listener.Service.Name != "" {
+			if err := validateListenerServiceName(r, listener.Service.Name); err != nil {
+				return nil, fmt.Errorf(`the field "%s.service.name" is invalid: %w`, path, err)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// validateListenerServiceName makes sure the service of the listener does not overwrite the other services of EMQX
+func validateListenerServiceName(r *EMQX, name string) error {
+	if slices.Contains([]string{"headless", "dashboard", "listeners"}, name) {
+		return fmt.Errorf(`"%s" is reserved`, name)
+	}
+	svcName := r.ListenerServiceNamespacedName(name).Name
+	if errs := validation.IsDNS1035Label(svcName); len(errs) > 0 {
+		return fmt.Errorf(`"%s" is not a valid service name: %s`, svcName, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateEMQXTLS(r *EMQX) (admission.Warnings, error) {
+	listeners := map[string]string{}
+	for i, tls := range r.Spec.TLS {
+		path := fmt.Sprintf(".spec.tls[%d]", i)
+		for _, listener := range tls.Listeners {
+			listenerType, name, _ := strings.Cut(listener, "-")
+			if name == "" || (listenerType != "ssl" && listenerType != "wss" && listenerType != "quic") {
+				return nil, fmt.Errorf(`the listener "%s" in "%s.listeners" must be "<type>-<name>" of the ssl, wss or quic listener`, listener, path)
+			}
+			if p, ok := listeners[listener]; ok {
+				return nil, fmt.Errorf(`the listener "%s" is used by both "%s" and "%s"`, listener, p, path)
+			}
+			listeners[listener] = path
+		}
+	}
+	for _, listener := range r.Spec.Listeners {
+		name := GetListenerServicePort(listener).Name
+		if p, ok := listeners[name]; ok && listener.TLSSecretRef != nil {
+			return nil, fmt.Errorf(`the listener "%s" uses both the "tlsSecretRef" and "%s"`, name, p)
+		}
+	}
+	return nil, nil
+}
+
+func validateBootstrapAPIKeyRotation(r *EMQX) (admission.Warnings, error) {
+	rotation := r.Spec.BootstrapAPIKeyRotation
+	if rotation == nil || rotation.Interval == nil {
+		return nil, nil
+	}
+	// Avoid rotating the API key of the operator on every reconcile
+	if rotation.Interval.Duration < time.Hour {
+		return nil, errors.New(`the field ".spec.bootstrapAPIKeyRotation.interval" must be at least 1h`)
+	}
+	return nil, nil
+}
+
+func validateNetworkPolicy(r *EMQX) (admission.Warnings, error) {
+	if r.Spec.NetworkPolicy == nil {
+		return nil, nil
+	}
+	for i, cidr := range r.Spec.NetworkPolicy.DashboardCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf(`the field ".spec.networkPolicy.dashboardCIDRs[%d]" is not a valid CIDR: %s`, i, cidr)
+		}
+	}
+	return nil, nil
+}
+
+const nodeCookieEnvError = `the "EMQX_NODE__COOKIE" environment variable cannot be set or updated, set the "node.cookie" in the EMQX config instead`
+
+// getNodeCookieEnvs returns the EMQX_NODE__COOKIE environment variables of the EMQX nodes,
+// they override the node cookie secret managed by the operator.
+func getNodeCookieEnvs(r *EMQX) []corev1.EnvVar {
+	env := slices.Clone(r.Spec.CoreTemplate.Spec.Env)
+	if r.Spec.ReplicantTemplate != nil {
+		env = append(env, r.Spec.ReplicantTemplate.Spec.Env...)
+	}
+	return slices.DeleteFunc(env, func(e corev1.EnvVar) bool {
+		return e.Name != "EMQX_NODE__COOKIE"
+	})
+}
+
+func validateEMQXReplicas(r *EMQX) (admission.Warnings, error) {
+	if r.Spec.CoreTemplate.Spec.Replicas != nil && *r.Spec.CoreTemplate.Spec.Replicas < 1 {
+		return nil, errors.New(`the field ".spec.coreTemplate.spec.replicas" must be at least 1`)
+	}
+
+	warnings := admission.Warnings{}
+	w, err := validatePodDisruptionBudget(".spec.coreTemplate.spec", &r.Spec.CoreTemplate.Spec.EMQXReplicantTemplateSpec)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, w...)
+	if r.Spec.ReplicantTemplate != nil {
+		w, err := validatePodDisruptionBudget(".spec.replicantTemplate.spec", &r.Spec.ReplicantTemplate.Spec)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w...)
+	}
+	return warnings, nil
+}
+
+func validatePodDisruptionBudget(path string, spec *EMQXReplicantTemplateSpec) (admission.Warnings, error) {
+	if spec.MinAvailable != nil && spec.MaxUnavailable != nil {
+		return nil, fmt.Errorf(`the field "%s.minAvailable" and "%s.maxUnavailable" are mutually exclusive`, path, path)
+	}
+	replicas := 1
+	if spec.Replicas != nil {
+		replicas = int(*spec.Replicas)
+	}
+
+	if spec.MinAvailable != nil {
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(spec.MinAvailable, replicas, true)
+		if err != nil {
+			return nil, emperror.Wrapf(err, `the field "%s.minAvailable" is invalid`, path)
+		}
+		if minAvailable > replicas {
+			return nil, fmt.Errorf(`the field "%s.minAvailable" must not be greater than "%s.replicas"`, path, path)
+		}
+		if replicas > 0 && minAvailable == replicas {
+			return admission.Warnings{fmt.Sprintf(`the field "%s.minAvailable" equals "%s.replicas", no EMQX node can be evicted voluntarily`, path, path)}, nil
+		}
+	}
+	if spec.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(spec.MaxUnavailable, replicas, true)
+		if err != nil {
+			return nil, emperror.Wrapf(err, `the field "%s.maxUnavailable" is invalid`, path)
+		}
+		if replicas > 0 && maxUnavailable == 0 {
+			return admission.Warnings{fmt.Sprintf(`the field "%s.maxUnavailable" is 0, no EMQX node can be evicted voluntarily`, path)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// validateReadOnlyConfig warns about the config that will not be updated in the running EMQX cluster before EMQX 5.7.0
+func validateReadOnlyConfig(r *EMQX) admission.Warnings {
+	if len(r.Status.CoreNodes) == 0 {
+		return nil
+	}
+	v, err := semver.NewVersion(r.Status.CoreNodes[0].Version)
+	if err != nil || !v.LessThan(semver.MustParse("5.7.0")) {
+		return nil
+	}
+
+	hoconConfig, err := hocon.ParseString(r.Spec.Config.Data)
+	if err != nil {
+		return nil
+	}
+	roots := []string{}
+	for _, root := range []string{"node", "cluster", "dashboard", "rpc"} {
+		if _, ok := hoconConfig.GetRoot().(hocon.Object)[root]; ok {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	return admission.Warnings{fmt.Sprintf("the `%s` config will not be updated in the running EMQX %s cluster, because it's readonly config before EMQX 5.7.0", strings.Join(roots, "`, `"), v.Original())}
+}
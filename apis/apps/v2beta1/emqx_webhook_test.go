@@ -0,0 +1,310 @@
+/*
+Copyright 2021.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2beta1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func TestEMQXDefault(t *testing.T) {
+	emqx := &EMQX{}
+	emqx.Spec.UpdateStrategy.Type = UpdateStrategyRollingUpdate
+	emqx.Default()
+	assert.Equal(t, &RollingUpdateStrategy{
+		MaxSurge:       ptr.To(intstr.FromString("25%")),
+		MaxUnavailable: ptr.To(intstr.FromInt(0)),
+	}, emqx.Spec.UpdateStrategy.RollingUpdate)
+
+	emqx = &EMQX{}
+	emqx.Spec.UpdateStrategy.Type = UpdateStrategyRecreate
+	emqx.Default()
+	assert.Nil(t, emqx.Spec.UpdateStrategy.RollingUpdate)
+}
+
+func TestEMQXValidateCreate(t *testing.T) {
+	emqx := EMQX{
+		Spec: EMQXSpec{
+			Image: "emqx/emqx:5.8.0",
+			CoreTemplate: EMQXCoreTemplate{
+				Spec: EMQXCoreTemplateSpec{
+					EMQXReplicantTemplateSpec: EMQXReplicantTemplateSpec{
+						Replicas: ptr.To(int32(3)),
+					},
+				},
+			},
+		},
+	}
+	_, err := emqx.ValidateCreate()
+	assert.NoError(t, err)
+
+	t.Run("invalid config", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.Config.Data = "listeners.tcp.default {"
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, "not a valid HOCON config")
+
+		e = emqx.DeepCopy()
+		e.Spec.ReplicantTemplate = &EMQXReplicantTemplate{
+			Config: &RoleConfig{Data: "node {"},
+		}
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, ".spec.replicantTemplate.config.data")
+	})
+
+	t.Run("port collision", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.Config.Data = "listeners.tcp.default.bind = 18083"
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, "collides")
+
+		e.Spec.Config.Data = "listeners.tcp.default.bind = 1883\nlisteners.ws.default.bind = 1883"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "collides")
+
+		// The QUIC listener is UDP
+		e.Spec.Config.Data = "listeners.tcp.default.bind = 14567\nlisteners.quic.default.bind = 14567"
+		_, err = e.ValidateCreate()
+		assert.NoError(t, err)
+	})
+
+	t.Run("listeners", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.Config.Data = "listeners.tcp.default.bind = 1883\nlisteners.ws.default.bind = 1883"
+		e.Spec.Listeners = []Listener{
+			{Type: "tcp", Name: "default", Port: 1883},
+			{Type: "ssl", Name: "default", Port: 8883, TLSSecretRef: &corev1.LocalObjectReference{Name: "tls"}},
+		}
+		// The listeners in the config are ignored when .spec.listeners is set
+		_, err := e.ValidateCreate()
+		assert.NoError(t, err)
+
+		e.Spec.Listeners[1].Port = 1883
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "collides")
+
+		e.Spec.Listeners[1].Port = 8883
+		e.Spec.Listeners[1].Type = "tcp"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "duplicated")
+
+		e.Spec.Listeners[1].Name = "internal"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "tlsSecretRef")
+
+		e.Spec.Listeners[1].Name = "very-long-name"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "not a valid port name")
+
+		e.Name = "emqx"
+		e.Spec.Listeners[1].Name = "internal"
+		e.Spec.Listeners[1].TLSSecretRef = nil
+		e.Spec.Listeners[1].Service = &ListenerService{Name: "internal"}
+		_, err = e.ValidateCreate()
+		assert.NoError(t, err)
+
+		e.Spec.Listeners[1].Service.Name = "headless"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "reserved")
+
+		e.Spec.Listeners[1].Service.Name = "Internal"
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "not a valid service name")
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.TLS = []TLSCertificate{
+			{Name: "mqtts", Listeners: []string{"ssl-default", "wss-default"}},
+		}
+		_, err := e.ValidateCreate()
+		assert.NoError(t, err)
+
+		e.Spec.TLS = append(e.Spec.TLS, TLSCertificate{Name: "other", Listeners: []string{"ssl-default"}})
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "used by both")
+
+		e.Spec.TLS[1].Listeners = []string{"tcp-default"}
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "ssl, wss or quic")
+
+		e.Spec.TLS = e.Spec.TLS[:1]
+		e.Spec.Listeners = []Listener{
+			{Type: "ssl", Name: "default", Port: 8883, TLSSecretRef: &corev1.LocalObjectReference{Name: "tls"}},
+		}
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "tlsSecretRef")
+	})
+
+	t.Run("bootstrap API key rotation", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.BootstrapAPIKeyRotation = &BootstrapAPIKeyRotation{Interval: &metav1.Duration{Duration: time.Minute}}
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, "must be at least 1h")
+
+		e.Spec.BootstrapAPIKeyRotation.Interval.Duration = 2160 * time.Hour
+		_, err = e.ValidateCreate()
+		assert.NoError(t, err)
+	})
+
+	t.Run("network policy", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.NetworkPolicy = &NetworkPolicy{DashboardCIDRs: []string{"10.0.0.0/8", "10.0.0.1"}}
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, `".spec.networkPolicy.dashboardCIDRs[1]" is not a valid CIDR`)
+
+		e.Spec.NetworkPolicy.DashboardCIDRs[1] = "10.0.0.1/32"
+		_, err = e.ValidateCreate()
+		assert.NoError(t, err)
+	})
+
+	t.Run("replicas", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.CoreTemplate.Spec.Replicas = ptr.To(int32(0))
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, "must be at least 1")
+	})
+
+	t.Run("pod disruption budget", func(t *testing.T) {
+		e := emqx.DeepCopy()
+		e.Spec.CoreTemplate.Spec.MinAvailable = ptr.To(intstr.FromInt(4))
+		_, err := e.ValidateCreate()
+		assert.ErrorContains(t, err, "must not be greater than")
+
+		e.Spec.CoreTemplate.Spec.MinAvailable = ptr.To(intstr.FromString("100%"))
+		warnings, err := e.ValidateCreate()
+		assert.NoError(t, err)
+		assert.Len(t, warnings, 1)
+
+		e.Spec.CoreTemplate.Spec.MinAvailable = nil
+		e.Spec.ReplicantTemplate = &EMQXReplicantTemplate{
+			Spec: EMQXReplicantTemplateSpec{
+				Replicas:       ptr.To(int32(2)),
+				MaxUnavailable: ptr.To(intstr.FromString("fake")),
+			},
+		}
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, "maxUnavailable")
+	})
+}
+
+func TestEMQXValidateUpdate(t *testing.T) {
+	oldEMQX := &EMQX{
+		Spec: EMQXSpec{
+			Image: "emqx/emqx:5.6.0",
+			BootstrapAPIKeys: []BootstrapAPIKey{
+				{Key: "foo", Secret: "bar"},
+			},
+		},
+	}
+	oldEMQX.Status.CoreNodes = []EMQXNode{{Version: "5.6.0"}}
+
+	t.Run("bootstrap API keys cannot be updated", func(t *testing.T) {
+		e := oldEMQX.DeepCopy()
+		e.Spec.BootstrapAPIKeys[0].Secret = "baz"
+		_, err := e.ValidateUpdate(oldEMQX)
+		assert.ErrorContains(t, err, "cannot be updated")
+	})
+
+	t.Run("core update mode cannot be updated from BlueGreen to InPlace", func(t *testing.T) {
+		old := oldEMQX.DeepCopy()
+		old.Spec.UpdateStrategy.CoreUpdateMode = CoreUpdateModeBlueGreen
+		e := old.DeepCopy()
+		e.Spec.UpdateStrategy.CoreUpdateMode = CoreUpdateModeInPlace
+		_, err := e.ValidateUpdate(old)
+		assert.ErrorContains(t, err, "cannot be updated from BlueGreen to InPlace")
+
+		_, err = old.ValidateUpdate(e)
+		assert.NoError(t, err)
+	})
+
+	t.Run("node cookie", func(t *testing.T) {
+		old := oldEMQX.DeepCopy()
+		old.Spec.Config.Data = `node.cookie = "foo"`
+		e := old.DeepCopy()
+		e.Spec.Config.Data = "node.cookie = \"foo\"\nlog.console.level = warning"
+		warnings, err := e.ValidateUpdate(old)
+		assert.NoError(t, err)
+		assert.NotContains(t, warnings, `the node cookie will be rotated to the "node.cookie" in ".spec.config.data", the EMQX nodes will be rolled by the blue-green update`)
+
+		e.Spec.Config.Data = `node.cookie = "bar"`
+		warnings, err = e.ValidateUpdate(old)
+		assert.NoError(t, err)
+		assert.Contains(t, warnings, `the node cookie will be rotated to the "node.cookie" in ".spec.config.data", the EMQX nodes will be rolled by the blue-green update`)
+
+		e.Annotations = map[string]string{AnnotationsRotateNodeCookieKey: ""}
+		warnings, err = e.ValidateUpdate(old)
+		assert.NoError(t, err)
+		assert.Contains(t, warnings, `the node cookie is set by the "node.cookie" in ".spec.config.data", it will not be rotated to a random one by the annotation`)
+		e.Annotations = nil
+
+		e.Spec.UpdateStrategy.CoreUpdateMode = CoreUpdateModeInPlace
+		old.Spec.UpdateStrategy.CoreUpdateMode = CoreUpdateModeInPlace
+		warnings, err = e.ValidateUpdate(old)
+		assert.NoError(t, err)
+		assert.Contains(t, warnings, `the node cookie cannot be rotated to the "node.cookie" in ".spec.config.data" by the in-place update`)
+		e.Spec.UpdateStrategy.CoreUpdateMode = oldEMQX.Spec.UpdateStrategy.CoreUpdateMode
+		old.Spec.UpdateStrategy.CoreUpdateMode = oldEMQX.Spec.UpdateStrategy.CoreUpdateMode
+
+		e.Spec.Config.Data = ""
+		e.Spec.CoreTemplate.Spec.Env = []corev1.EnvVar{{Name: "EMQX_NODE__COOKIE", Value: "bar"}}
+		_, err = e.ValidateUpdate(old)
+		assert.ErrorContains(t, err, `"EMQX_NODE__COOKIE" environment variable cannot be set or updated`)
+		_, err = e.ValidateCreate()
+		assert.ErrorContains(t, err, `"EMQX_NODE__COOKIE" environment variable cannot be set or updated`)
+
+		// The existing environment variable is kept
+		old.Spec.CoreTemplate.Spec.Env = e.Spec.CoreTemplate.Spec.Env
+		_, err = e.ValidateUpdate(old)
+		assert.NoError(t, err)
+	})
+
+	t.Run("env config conflicts", func(t *testing.T) {
+		old := oldEMQX.DeepCopy()
+		old.Spec.Config.Data = "listeners.tcp.default.bind = 1883\nlog.console.level = warning"
+		old.Spec.CoreTemplate.Spec.Env = []corev1.EnvVar{{Name: "EMQX_LISTENERS__TCP__DEFAULT__BIND", Value: "11883"}}
+		_, err := old.ValidateCreate()
+		assert.ErrorContains(t, err, "EMQX_LISTENERS__TCP__DEFAULT__BIND overrides listeners.tcp.default.bind")
+
+		// The existing conflicts are warned
+		e := old.DeepCopy()
+		warnings, err := e.ValidateUpdate(old)
+		assert.NoError(t, err)
+		assert.Len(t, warnings, 1)
+
+		e.Spec.CoreTemplate.Spec.Env = append(e.Spec.CoreTemplate.Spec.Env, corev1.EnvVar{Name: "EMQX_LOG__CONSOLE__LEVEL", Value: "debug"})
+		_, err = e.ValidateUpdate(old)
+		assert.ErrorContains(t, err, "EMQX_LOG__CONSOLE__LEVEL overrides log.console.level")
+	})
+
+	t.Run("warn readonly config", func(t *testing.T) {
+		e := oldEMQX.DeepCopy()
+		e.Spec.Config.Data = "node.process_limit = 2097152\nmqtt.max_topic_levels = 64"
+		warnings, err := e.ValidateUpdate(oldEMQX)
+		assert.NoError(t, err)
+		assert.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "`node`")
+
+		e.Status.CoreNodes = []EMQXNode{{Version: "5.7.0"}}
+		warnings, err = e.ValidateUpdate(oldEMQX)
+		assert.NoError(t, err)
+		assert.Empty(t, warnings)
+	})
+}
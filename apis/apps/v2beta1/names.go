@@ -57,6 +57,13 @@ func (instance *EMQX) ListenersServiceNamespacedName() types.NamespacedName {
 	}
 }
 
+func (instance *EMQX) ListenerServiceNamespacedName(name string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      fmt.Sprintf("%s-%s", instance.Name, name),
+	}
+}
+
 func (instance *EMQX) BootstrapAPIKeyNamespacedName() types.NamespacedName {
 	return types.NamespacedName{
 		Namespace: instance.Namespace,
@@ -64,6 +71,13 @@ func (instance *EMQX) BootstrapAPIKeyNamespacedName() types.NamespacedName {
 	}
 }
 
+func (instance *EMQX) NetworkPolicyNamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      fmt.Sprintf("%s-network-policy", instance.Name),
+	}
+}
+
 func (instance *EMQX) NodeCookieNamespacedName() types.NamespacedName {
 	return types.NamespacedName{
 		Namespace: instance.Namespace,
@@ -77,3 +91,24 @@ func (instance *EMQX) ConfigsNamespacedName() types.NamespacedName {
 		Name:      fmt.Sprintf("%s-configs", instance.Name),
 	}
 }
+
+func (instance *EMQX) CoreConfigsNamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      fmt.Sprintf("%s-core-configs", instance.Name),
+	}
+}
+
+func (instance *EMQX) ReplicantConfigsNamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      fmt.Sprintf("%s-replicant-configs", instance.Name),
+	}
+}
+
+func (instance *EMQX) TLSCertificateNamespacedName(name string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      fmt.Sprintf("%s-%s-tls", instance.Name, name),
+	}
+}
@@ -35,6 +35,56 @@ type EMQXStatus struct {
 	ReplicantNodesStatus EMQXNodesStatus `json:"replicantNodesStatus,omitempty"`
 
 	NodeEvacuationsStatus []NodeEvacuationStatus `json:"nodeEvacuationsStatus,omitempty"`
+
+	CanaryStatus *CanaryStatus `json:"canaryStatus,omitempty"`
+
+	// The update revisions of the EMQX nodes that the postSwitch hook is pending for.
+	PostSwitchRevision string `json:"postSwitchRevision,omitempty"`
+
+	// The revisions of the EMQX nodes that are still retained, sorted by creation time.
+	Revisions []EMQXRevision `json:"revisions,omitempty"`
+
+	// The result of syncing the EMQX config to the EMQX cluster.
+	Config EMQXConfigStatus `json:"config,omitempty"`
+}
+
+type EMQXConfigStatus struct {
+	// The hash of the EMQX config that was last applied, including the config sources and the resolved secrets
+	ObservedHash string `json:"observedHash,omitempty"`
+	// The time when the EMQX config was last applied successfully
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+	// The mode used to apply the EMQX config, enum: "Merge" "Replace"
+	Mode string `json:"mode,omitempty"`
+	// The top-level keys skipped when the EMQX config was last applied, because they are readonly
+	SkippedReadOnlyKeys []string `json:"skippedReadOnlyKeys,omitempty"`
+	// The last error of applying the EMQX config, it's cleared once the EMQX config is applied successfully
+	LastError string `json:"lastError,omitempty"`
+	// The time when the config of the running EMQX cluster was last compared with the EMQX config
+	LastDriftCheckTime *metav1.Time `json:"lastDriftCheckTime,omitempty"`
+}
+
+type EMQXRevision struct {
+	// EMQX node role of the revision, enum: "core" "replicant"
+	Role string `json:"role,omitempty"`
+	// The pod template hash of the revision
+	Revision string `json:"revision,omitempty"`
+	// EMQX image of the revision
+	Image string `json:"image,omitempty"`
+	// The hash of the EMQX config when the revision was created
+	ConfigHash string `json:"configHash,omitempty"`
+	// The replicas of the revision
+	Replicas int32 `json:"replicas,omitempty"`
+	// The time when the revision was created
+	CreationTime metav1.Time `json:"creationTime,omitempty"`
+}
+
+type CanaryStatus struct {
+	// The pod template hash of the revision that the canary steps are applied to.
+	Revision string `json:"revision,omitempty"`
+	// Index of the canary step in progress.
+	CurrentStepIndex int32 `json:"currentStepIndex"`
+	// The time when the current step was reached, and the pause started.
+	CurrentStepReachedTime *metav1.Time `json:"currentStepReachedTime,omitempty"`
 }
 
 type NodeEvacuationStatus struct {
@@ -102,6 +152,38 @@ const (
 	Ready                     string = "Ready"
 )
 
+const (
+	// RollbackTriggered means the update revision did not become ready in time, and the EMQX spec was restored to the current revision.
+	// It's false if the current revision can not be restored, e.g. its set was created without the revision spec annotation.
+	RollbackTriggered string = "RollbackTriggered"
+	// Paused means the update is paused by .spec.updateStrategy.paused, it's false after the update is resumed.
+	Paused string = "Paused"
+	// PreSwitchHookFailed means the preSwitch hook Job failed, and the update is blocked.
+	PreSwitchHookFailed string = "PreSwitchHookFailed"
+	// AnalysisFailed means the metrics of the new EMQX nodes regress compared with the old EMQX nodes.
+	AnalysisFailed string = "AnalysisFailed"
+	// UpgradePathSupported means the transition from the running EMQX version to the version of the image is supported,
+	// when it's false, the new EMQX nodes will not be created.
+	UpgradePathSupported string = "UpgradePathSupported"
+	// ConfigDrifted means the config of the running EMQX cluster differs from the EMQX config.
+	ConfigDrifted string = "ConfigDrifted"
+	// ConfigOverriddenByEnv means the EMQX config is overridden by the EMQX_ environment variables of the EMQX nodes.
+	ConfigOverriddenByEnv string = "ConfigOverriddenByEnv"
+	// NodeCookieRotating means the EMQX nodes are rolled to the rotated node cookie, the new EMQX nodes accept the previous
+	// node cookie of the old EMQX nodes until they are removed. It's false if the node cookie can not be rotated.
+	NodeCookieRotating string = "NodeCookieRotating"
+)
+
+// isLifecycleCondition returns true for the conditions of the EMQX cluster lifecycle,
+// the other conditions are informational and can be true at the same time.
+func isLifecycleCondition(conditionType string) bool {
+	switch conditionType {
+	case Initialized, CoreNodesProgressing, CoreNodesReady, ReplicantNodesProgressing, ReplicantNodesReady, Available, Ready:
+		return true
+	}
+	return false
+}
+
 func (s *EMQXStatus) SetCondition(c metav1.Condition) {
 	c.LastTransitionTime = metav1.Now()
 	pos, _ := s.GetCondition(c.Type)
@@ -118,7 +200,7 @@ func (s *EMQXStatus) SetCondition(c metav1.Condition) {
 func (s *EMQXStatus) GetLastTrueCondition() *metav1.Condition {
 	for i := range s.Conditions {
 		c := s.Conditions[i]
-		if c.Status == metav1.ConditionTrue {
+		if c.Status == metav1.ConditionTrue && isLifecycleCondition(c.Type) {
 			return &c
 		}
 	}
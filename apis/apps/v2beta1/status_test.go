@@ -111,6 +111,15 @@ func TestGetLastTrueCondition(t *testing.T) {
 
 	c := status.GetLastTrueCondition()
 	assert.Equal(t, Initialized, c.Type)
+
+	status.Conditions = append([]metav1.Condition{
+		{
+			Type:   RollbackTriggered,
+			Status: metav1.ConditionTrue,
+		},
+	}, status.Conditions...)
+	c = status.GetLastTrueCondition()
+	assert.Equal(t, Initialized, c.Type)
 }
 
 func TestGetCondition(t *testing.T) {
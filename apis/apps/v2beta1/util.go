@@ -19,6 +19,7 @@ package v2beta1
 import (
 	"fmt"
 	"net"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -216,6 +217,18 @@ func GetDashboardPortMap(hoconString string) (map[string]int32, error) {
 	return portMap, nil
 }
 
+// GetNodeCookie returns the `node.cookie` of the EMQX config, or an empty string if it's not set.
+func GetNodeCookie(config string) string {
+	hoconConfig, err := hocon.ParseString(config)
+	if err != nil {
+		return ""
+	}
+	if _, ok := hoconConfig.GetRoot().(hocon.Object)["node"].(hocon.Object); !ok {
+		return ""
+	}
+	return hoconConfig.GetString("node.cookie")
+}
+
 func GetDashboardServicePort(hoconString string) ([]corev1.ServicePort, error) {
 	dashboardSvcPortList := []corev1.ServicePort{}
 	portMap, err := GetDashboardPortMap(hoconString)
@@ -367,6 +380,105 @@ func GetListenersServicePorts(hoconString string) ([]corev1.ServicePort, error)
 	return svcPorts, nil
 }
 
+// GetEnvConfigPath returns the EMQX config path overridden by the environment variable,
+// for example "listeners.tcp.default.bind" for "EMQX_LISTENERS__TCP__DEFAULT__BIND".
+func GetEnvConfigPath(name string) (string, bool) {
+	if !strings.HasPrefix(name, "EMQX_") || !strings.Contains(strings.TrimPrefix(name, "EMQX_"), "__") {
+		return "", false
+	}
+	keys := strings.Split(strings.TrimPrefix(name, "EMQX_"), "__")
+	for i, key := range keys {
+		if key == "" {
+			return "", false
+		}
+		keys[i] = strings.ToLower(key)
+	}
+	return strings.Join(keys, "."), true
+}
+
+// GetEnvConfigConflicts returns the conflicts between the EMQX_ environment variables and the EMQX config, sorted by the names.
+func GetEnvConfigConflicts(env []corev1.EnvVar, config string) []string {
+	hoconConfig, err := hocon.ParseString(config)
+	if err != nil {
+		return nil
+	}
+	if _, ok := hoconConfig.GetRoot().(hocon.Object); !ok {
+		return nil
+	}
+	conflicts := []string{}
+	for _, e := range env {
+		path, ok := GetEnvConfigPath(e.Name)
+		if !ok || !hasConfigPath(hoconConfig.GetRoot(), strings.Split(path, ".")) {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s overrides %s", e.Name, path))
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// GetEMQXEnvConfigConflicts returns the conflicts between the EMQX_ environment variables of the EMQX nodes and the EMQX config,
+// the config of the EMQX nodes role is merged on top of the EMQX config.
+func GetEMQXEnvConfigConflicts(instance *EMQX, config string) []string {
+	coreConfig := config
+	if instance.Spec.CoreTemplate.Config != nil {
+		coreConfig += "\n" + instance.Spec.CoreTemplate.Config.Data
+	}
+	conflicts := GetEnvConfigConflicts(instance.Spec.CoreTemplate.Spec.Env, coreConfig)
+	if instance.Spec.ReplicantTemplate != nil {
+		replicantConfig := config
+		if instance.Spec.ReplicantTemplate.Config != nil {
+			replicantConfig += "\n" + instance.Spec.ReplicantTemplate.Config.Data
+		}
+		for _, conflict := range GetEnvConfigConflicts(instance.Spec.ReplicantTemplate.Spec.Env, replicantConfig) {
+			if !slices.Contains(conflicts, conflict) {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// hasConfigPath returns true if the config sets the path, or the parent of the path
+func hasConfigPath(value hocon.Value, keys []string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	obj, ok := value.(hocon.Object)
+	if !ok {
+		return true
+	}
+	sub, ok := obj[keys[0]]
+	if !ok {
+		return false
+	}
+	return hasConfigPath(sub, keys[1:])
+}
+
+// GetListenerServicePort returns the service port of the listener in .spec.listeners
+func GetListenerServicePort(listener Listener) corev1.ServicePort {
+	protocol := corev1.ProtocolTCP
+	if listener.Type == "quic" {
+		protocol = corev1.ProtocolUDP
+	}
+	port := corev1.ServicePort{
+		Name:       fmt.Sprintf("%s-%s", listener.Type, listener.Name),
+		Protocol:   protocol,
+		Port:       listener.Port,
+		TargetPort: intstr.FromInt(int(listener.Port)),
+	}
+	if listener.Service != nil {
+		port.NodePort = listener.Service.NodePort
+	}
+	return port
+}
+
+// IsListenerEnabled returns true if the listener in .spec.listeners is enabled
+func IsListenerEnabled(listener Listener) bool {
+	return listener.Enabled == nil || *listener.Enabled
+}
+
 func MergeServicePorts(ports1, ports2 []corev1.ServicePort) []corev1.ServicePort {
 	ports := append(ports1, ports2...)
 
@@ -349,3 +349,43 @@ func TestMergeMap(t *testing.T) {
 	}
 	assert.Equal(t, expect, mergeMap(m1, m2))
 }
+
+func TestGetEnvConfigPath(t *testing.T) {
+	path, ok := GetEnvConfigPath("EMQX_LISTENERS__TCP__DEFAULT__BIND")
+	assert.True(t, ok)
+	assert.Equal(t, "listeners.tcp.default.bind", path)
+
+	path, ok = GetEnvConfigPath("EMQX_MQTT__MAX_PACKET_SIZE")
+	assert.True(t, ok)
+	assert.Equal(t, "mqtt.max_packet_size", path)
+
+	for _, name := range []string{"EMQX_HOST", "EMQX_NODE_NAME", "EMQX__FOO", "FOO__BAR", "EMQX_FOO____BAR"} {
+		_, ok := GetEnvConfigPath(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestGetEMQXEnvConfigConflicts(t *testing.T) {
+	instance := &EMQX{}
+	instance.Spec.CoreTemplate.Spec.Env = []corev1.EnvVar{
+		{Name: "EMQX_LISTENERS__TCP__DEFAULT__BIND", Value: "11883"},
+		{Name: "EMQX_LOG__CONSOLE", Value: "{level = debug}"},
+		{Name: "EMQX_RETAINER__ENABLE", Value: "true"},
+		{Name: "EMQX_HOST", Value: "127.0.0.1"},
+	}
+	instance.Spec.ReplicantTemplate = &EMQXReplicantTemplate{
+		Config: &RoleConfig{Data: "mqtt.max_topic_levels = 64"},
+	}
+	instance.Spec.ReplicantTemplate.Spec.Env = []corev1.EnvVar{
+		{Name: "EMQX_LISTENERS__TCP__DEFAULT__BIND", Value: "11883"},
+		{Name: "EMQX_MQTT__MAX_TOPIC_LEVELS", Value: "128"},
+	}
+
+	assert.Equal(t, []string{
+		"EMQX_LISTENERS__TCP__DEFAULT__BIND overrides listeners.tcp.default.bind",
+		"EMQX_LOG__CONSOLE overrides log.console",
+		"EMQX_MQTT__MAX_TOPIC_LEVELS overrides mqtt.max_topic_levels",
+	}, GetEMQXEnvConfigConflicts(instance, "listeners.tcp.default.bind = 1883\nlog.console.level = warning"))
+	assert.Empty(t, GetEMQXEnvConfigConflicts(&EMQX{}, "listeners.tcp.default.bind = 1883"))
+	assert.Empty(t, GetEnvConfigConflicts(instance.Spec.CoreTemplate.Spec.Env, ""))
+}
@@ -14,7 +14,6 @@ import (
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
-	"github.com/rory-z/go-hocon"
 	"github.com/sethvargo/go-password/password"
 )
 
@@ -105,10 +104,7 @@ func generateBootstrapAPIKeySecret(instance *appsv2beta1.EMQX, bootstrapAPIKeys
 }
 
 func generateNodeCookieSecret(instance *appsv2beta1.EMQX) *corev1.Secret {
-	var cookie string
-
-	config, _ := hocon.ParseString(instance.Spec.Config.Data)
-	cookie = config.GetString("node.cookie")
+	cookie := appsv2beta1.GetNodeCookie(instance.Spec.Config.Data)
 	if cookie == "" {
 		cookie, _ = password.Generate(64, 10, 0, true, true)
 	}
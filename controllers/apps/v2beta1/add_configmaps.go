@@ -0,0 +1,140 @@
+package v2beta1
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	"github.com/rory-z/go-hocon"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// appliedConfigKey is the key of the EMQX config in the configMap that was last applied to the running EMQX cluster,
+// it's the baseline to find the changed config to push by the EMQX API.
+// The "emqx.conf" key is updated before the new EMQX nodes are created, so they start with the new config.
+const appliedConfigKey = "applied.emqx.conf"
+
+type addConfigMaps struct {
+	*EMQXReconciler
+}
+
+// reconcile writes the EMQX config to the configMaps mounted by the EMQX nodes. It runs before the EMQX nodes are created,
+// so the EMQX nodes rolled for the changed readonly config start with the new config.
+func (a *addConfigMaps) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, _ innerReq.RequesterInterface) subResult {
+	_, confStr, _, err := a.renderEMQXConfig(ctx, instance)
+	if err != nil {
+		return subResult{err: err}
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := a.Client.Get(ctx, instance.ConfigsNamespacedName(), configMap); err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return subResult{err: emperror.Wrap(err, "failed to get configMap")}
+		}
+		// The EMQX config is applied by the configMap when the EMQX nodes start
+		configMap = generateConfigMap(instance, confStr)
+		configMap.Data[appliedConfigKey] = confStr
+		if err := ctrl.SetControllerReference(instance, configMap, a.Scheme); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to set controller reference for configMap")}
+		}
+		if err := a.Client.Create(ctx, configMap); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to create configMap")}
+		}
+	} else if configMap.Data["emqx.conf"] != confStr {
+		if _, ok := configMap.Data[appliedConfigKey]; !ok {
+			// The configMap was created before the applied config is recorded, it's the applied config until now
+			configMap.Data[appliedConfigKey] = configMap.Data["emqx.conf"]
+		}
+		configMap.Data["emqx.conf"] = confStr
+		if err := a.Client.Update(ctx, configMap); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update configMap")}
+		}
+	}
+
+	if err := a.syncRoleConfigMaps(ctx, instance, confStr); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to sync configMap of the EMQX nodes role")}
+	}
+	return subResult{}
+}
+
+// renderEMQXConfig returns the EMQX config merged from the config sources with the resolved secret placeholders,
+// the EMQX config with the listeners and TLS config for the configMap, and the hash of the config sources and the secrets.
+func (r *EMQXReconciler) renderEMQXConfig(ctx context.Context, instance *appsv2beta1.EMQX) (config, confStr, sourcesHash string, err error) {
+	sources, err := getConfigSources(ctx, r.Client, instance)
+	if err != nil {
+		return "", "", "", emperror.Wrap(err, "failed to get config sources")
+	}
+	config = instance.Spec.Config.Data
+	if sources != "" {
+		config = sources + "\n" + config
+		if _, err := hocon.ParseString(config); err != nil {
+			r.EventRecorder.Event(instance, corev1.EventTypeWarning, "InvalidConfig", "the merged config of .spec.config.sources is not a valid HOCON config")
+			return "", "", "", emperror.Wrap(err, "failed to parse config")
+		}
+	}
+	config, secretValues, err := resolveSecretPlaceholders(ctx, r.Client, instance, config)
+	if err != nil {
+		return "", "", "", emperror.Wrap(err, "failed to resolve secret placeholders")
+	}
+	// The config sources and the secrets are not in the EMQX spec, so use the hash of them to detect the changes
+	if len(instance.Spec.Config.Sources) > 0 || len(secretValues) > 0 {
+		sourcesHash = computeStringHash(sources + "\n" + strings.Join(secretValues, "\n"))
+	}
+	return config, mergeTLSConfig(instance, mergeListenersConfig(instance, config)), sourcesHash, nil
+}
+
+// syncRoleConfigMaps renders the EMQX config with the config of the EMQX nodes role into the configMap of the role.
+// The config of the role can not be updated by the EMQX API, because the EMQX API updates the config of the whole EMQX cluster,
+// so the EMQX nodes are rolled when it's changed.
+func (a *addConfigMaps) syncRoleConfigMaps(ctx context.Context, instance *appsv2beta1.EMQX, confStr string) error {
+	roleConfigs := map[string]string{
+		instance.CoreConfigsNamespacedName().Name: getRoleConfig(instance.Spec.CoreTemplate.Config),
+	}
+	if instance.Spec.ReplicantTemplate != nil {
+		roleConfigs[instance.ReplicantConfigsNamespacedName().Name] = getRoleConfig(instance.Spec.ReplicantTemplate.Config)
+	}
+
+	for name, roleConfig := range roleConfigs {
+		if roleConfig == "" {
+			continue
+		}
+		if _, err := hocon.ParseString(roleConfig); err != nil {
+			a.EventRecorder.Event(instance, corev1.EventTypeWarning, "InvalidConfig", "the config of the EMQX nodes role is not a valid HOCON config")
+			return emperror.Wrap(err, "failed to parse config")
+		}
+		roleConfig, _, err := resolveSecretPlaceholders(ctx, a.Client, instance, roleConfig)
+		if err != nil {
+			return emperror.Wrap(err, "failed to resolve secret placeholders")
+		}
+
+		configMap := generateConfigMap(instance, confStr+"\n"+roleConfig)
+		configMap.Name = name
+		storage := &corev1.ConfigMap{}
+		if err := a.Client.Get(ctx, client.ObjectKeyFromObject(configMap), storage); err != nil {
+			if !k8sErrors.IsNotFound(err) {
+				return emperror.Wrap(err, "failed to get configMap")
+			}
+			if err := ctrl.SetControllerReference(instance, configMap, a.Scheme); err != nil {
+				return emperror.Wrap(err, "failed to set controller reference for configMap")
+			}
+			if err := a.Client.Create(ctx, configMap); err != nil {
+				return emperror.Wrap(err, "failed to create configMap")
+			}
+			continue
+		}
+		if !reflect.DeepEqual(storage.Data, configMap.Data) {
+			storage.Data = configMap.Data
+			if err := a.Client.Update(ctx, storage); err != nil {
+				return emperror.Wrap(err, "failed to update configMap")
+			}
+		}
+	}
+	return nil
+}
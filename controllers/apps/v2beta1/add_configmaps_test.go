@@ -0,0 +1,110 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestAddConfigMaps(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "fake"},
+	}
+	instance.Spec.Image = "emqx/emqx:5.6.0"
+	instance.Spec.CoreTemplate.Spec.Replicas = ptr.To(int32(3))
+	instance.Spec.Config.RollOnReadOnlyChange = true
+	instance.Spec.Config.Data = "node.process_limit = 1048576"
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+	a := &addConfigMaps{
+		EMQXReconciler: &EMQXReconciler{
+			Handler:       &handler.Handler{Client: k8sClient},
+			Scheme:        scheme,
+			EventRecorder: record.NewFakeRecorder(10),
+		},
+	}
+	ctx := context.Background()
+
+	assert.Nil(t, a.reconcile(ctx, log.FromContext(ctx), instance, nil).err)
+	configMap := &corev1.ConfigMap{}
+	assert.Nil(t, k8sClient.Get(ctx, instance.ConfigsNamespacedName(), configMap))
+	assert.Contains(t, configMap.Data["emqx.conf"], "node.process_limit = 1048576")
+	assert.Equal(t, configMap.Data["emqx.conf"], configMap.Data[appliedConfigKey])
+	oldSts := getNewStatefulSet(instance)
+
+	// The readonly config is changed while the EMQX core nodes are not ready, because a new statefulSet is rolling out
+	instance.Spec.Config.Data = "node.process_limit = 2097152"
+	assert.Nil(t, a.reconcile(ctx, log.FromContext(ctx), instance, nil).err)
+	assert.Nil(t, k8sClient.Get(ctx, instance.ConfigsNamespacedName(), configMap))
+	assert.Contains(t, configMap.Data["emqx.conf"], "node.process_limit = 2097152")
+	// The config is not pushed by the EMQX API yet
+	assert.Contains(t, configMap.Data[appliedConfigKey], "node.process_limit = 1048576")
+
+	// The pods of the new statefulSet mount the updated configMap
+	newSts := getNewStatefulSet(instance)
+	assert.NotEqual(t, oldSts.Name, newSts.Name)
+	assert.Contains(t, newSts.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "bootstrap-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+			},
+		},
+	})
+}
+
+func TestSyncRoleConfigMaps(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "fake"},
+	}
+	instance.Spec.CoreTemplate.Config = &appsv2beta1.RoleConfig{Data: "node.process_limit = 2097152"}
+	instance.Spec.ReplicantTemplate = &appsv2beta1.EMQXReplicantTemplate{}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+	a := &addConfigMaps{
+		EMQXReconciler: &EMQXReconciler{
+			Handler:       &handler.Handler{Client: k8sClient},
+			Scheme:        scheme,
+			EventRecorder: record.NewFakeRecorder(10),
+		},
+	}
+
+	assert.Nil(t, a.syncRoleConfigMaps(context.Background(), instance, "a = 1"))
+	configMap := &corev1.ConfigMap{}
+	assert.Nil(t, k8sClient.Get(context.Background(), instance.CoreConfigsNamespacedName(), configMap))
+	assert.Equal(t, "a = 1\nnode.process_limit = 2097152", configMap.Data["emqx.conf"])
+	assert.Len(t, configMap.OwnerReferences, 1)
+	// The replicant nodes have no config, so they mount the shared configMap
+	assert.Error(t, k8sClient.Get(context.Background(), instance.ReplicantConfigsNamespacedName(), &corev1.ConfigMap{}))
+
+	assert.Nil(t, a.syncRoleConfigMaps(context.Background(), instance, "a = 2"))
+	assert.Nil(t, k8sClient.Get(context.Background(), instance.CoreConfigsNamespacedName(), configMap))
+	assert.Equal(t, "a = 2\nnode.process_limit = 2097152", configMap.Data["emqx.conf"])
+
+	instance.Spec.CoreTemplate.Config.Data = "node {"
+	assert.Error(t, a.syncRoleConfigMaps(context.Background(), instance, "a = 2"))
+}
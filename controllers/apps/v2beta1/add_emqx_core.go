@@ -29,7 +29,7 @@ type addCore struct {
 func (a *addCore) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, _ innerReq.RequesterInterface) subResult {
 	preSts := getNewStatefulSet(instance)
 	preStsHash := preSts.Labels[appsv2beta1.LabelsPodTemplateHashKey]
-	updateSts, _, _ := getStateFulSetList(ctx, a.Client, instance)
+	updateSts, currentSts, oldStsList := getStateFulSetList(ctx, a.Client, instance)
 
 	patchCalculateFunc := func(storage, new *appsv1.StatefulSet) *patch.PatchResult {
 		if storage == nil {
@@ -43,19 +43,34 @@ func (a *addCore) reconcile(ctx context.Context, logger logr.Logger, instance *a
 		return patchResult
 	}
 	if patchResult := patchCalculateFunc(updateSts, preSts); !patchResult.IsEmpty() {
+		if !isRolledOut(preStsHash, append([]*appsv1.StatefulSet{updateSts, currentSts}, oldStsList...)) && !a.checkUpgradePath(ctx, instance) {
+			// Only the new statefulSet is not created, the rest of the EMQX resources are still reconciled
+			return subResult{}
+		}
+		if isInPlaceUpdate(instance) && updateSts != nil && updateSts.Name == preSts.Name {
+			return a.updateStatefulSetInPlace(ctx, logger, instance, updateSts, preSts, string(patchResult.Patch))
+		}
+
 		// Create new statefulSet
 		logger.Info("got different pod template for EMQX core nodes, will create new statefulSet", "statefulSet", klog.KObj(preSts), "patch", string(patchResult.Patch))
 
+		preSts.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Annotations, appsv2beta1.AnnotationsRevisionSpecKey, generateRevisionSpec(instance))
+		preSts.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Annotations, appsv2beta1.AnnotationsRevisionConfigHashKey, computeStringHash(instance.Spec.Config.Data))
 		_ = ctrl.SetControllerReference(instance, preSts, a.Scheme)
 		if err := a.Handler.Create(ctx, preSts); err != nil {
 			if k8sErrors.IsAlreadyExists(emperror.Cause(err)) {
-				cond := instance.Status.GetLastTrueCondition()
-				if cond != nil && cond.Type != appsv2beta1.Available && cond.Type != appsv2beta1.Ready {
-					// Sometimes the updated statefulSet will not be ready, because the EMQX node can not be started.
-					// And then we will rollback EMQX CR spec, the EMQX operator controller will create a new statefulSet.
-					// But the new statefulSet will be the same as the previous one, so we didn't need to create it, just change the EMQX status.
-					if preStsHash == instance.Status.CoreNodesStatus.CurrentRevision {
-						_ = a.updateEMQXStatus(ctx, instance, "RevertStatefulSet", "Revert to current statefulSet", preStsHash)
+				// Sometimes the updated statefulSet will not be ready, because the EMQX node can not be started,
+				// or the new EMQX nodes regress in the update analysis.
+				// And then we will rollback EMQX CR spec, the EMQX operator controller will create a new statefulSet.
+				// But the new statefulSet will be the same as the previous one, so we didn't need to create it, just change the EMQX status.
+				if preStsHash == instance.Status.CoreNodesStatus.CurrentRevision {
+					_ = a.updateEMQXStatus(ctx, instance, "RevertStatefulSet", "Revert to current statefulSet", preStsHash)
+					return subResult{}
+				}
+				for _, sts := range oldStsList {
+					// Rollback to a retained revision, just scale the retained statefulSet up again.
+					if sts.Name == preSts.Name && patchCalculateFunc(sts, preSts).IsEmpty() {
+						_ = a.updateEMQXStatus(ctx, instance, "RollbackStatefulSet", "Rollback to retained statefulSet", preStsHash)
 						return subResult{}
 					}
 				}
@@ -72,9 +87,26 @@ func (a *addCore) reconcile(ctx context.Context, logger logr.Logger, instance *a
 		return subResult{}
 	}
 
+	if _, condition := instance.Status.GetCondition(appsv2beta1.UpgradePathSupported); condition != nil && condition.Status == metav1.ConditionFalse {
+		// The image is reverted to the running version
+		instance.Status.RemoveCondition(appsv2beta1.UpgradePathSupported)
+		if err := a.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+
 	preSts.ObjectMeta = updateSts.DeepCopy().ObjectMeta
 	preSts.Spec.Template.ObjectMeta = updateSts.DeepCopy().Spec.Template.ObjectMeta
 	preSts.Spec.Selector = updateSts.DeepCopy().Spec.Selector
+	if isInPlaceUpdate(instance) && updateSts.Name == preSts.Name {
+		// The partition is managed by syncPods
+		preSts.Spec.UpdateStrategy = *updateSts.Spec.UpdateStrategy.DeepCopy()
+		if instance.Status.CoreNodesStatus.UpdateRevision == instance.Status.CoreNodesStatus.CurrentRevision {
+			// All pods are updated, keep the revision spec for rollback up to date
+			preSts.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Annotations, appsv2beta1.AnnotationsRevisionSpecKey, generateRevisionSpec(instance))
+			preSts.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Annotations, appsv2beta1.AnnotationsRevisionConfigHashKey, computeStringHash(instance.Spec.Config.Data))
+		}
+	}
 	if patchResult, _ := a.Patcher.Calculate(
 		updateSts.DeepCopy(),
 		preSts.DeepCopy(),
@@ -96,6 +128,77 @@ func (a *addCore) reconcile(ctx context.Context, logger logr.Logger, instance *a
 	return subResult{}
 }
 
+// isRolledOut returns true if the pod template has been rolled out by one of the statefulSets,
+// like reverting to the current statefulSet, or rolling back to a retained statefulSet.
+func isRolledOut(podTemplateHash string, stsList []*appsv1.StatefulSet) bool {
+	for _, sts := range stsList {
+		if sts != nil && sts.Labels[appsv2beta1.LabelsPodTemplateHashKey] == podTemplateHash {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUpgradePath validates the upgrade path before the new EMQX core nodes are rolled out,
+// returns false if the transition is not supported.
+func (a *addCore) checkUpgradePath(ctx context.Context, instance *appsv2beta1.EMQX) bool {
+	path := checkUpgradePath(instance)
+	if path == nil {
+		return true
+	}
+
+	condition := metav1.Condition{
+		Type:    appsv2beta1.UpgradePathSupported,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Supported",
+		Message: path.Message,
+	}
+	if path.Reason != "" {
+		condition.Reason = path.Reason
+	}
+	if !path.Supported {
+		condition.Status = metav1.ConditionFalse
+	}
+
+	_, storage := instance.Status.GetCondition(appsv2beta1.UpgradePathSupported)
+	if storage != nil && storage.Status == condition.Status && storage.Reason == condition.Reason && storage.Message == condition.Message {
+		return path.Supported
+	}
+	if path.Reason != "" {
+		a.EventRecorder.Event(instance, corev1.EventTypeWarning, path.Reason, path.Message)
+	}
+	instance.Status.SetCondition(condition)
+	_ = a.Client.Status().Update(ctx, instance)
+	return path.Supported
+}
+
+// updateStatefulSetInPlace updates the pod template of the statefulSet, and sets the partition to the replicas,
+// so no pod is updated until syncPods moves the partition.
+func (a *addCore) updateStatefulSetInPlace(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, updateSts, preSts *appsv1.StatefulSet, patch string) subResult {
+	logger.Info("got different pod template for EMQX core nodes, will update statefulSet in place", "statefulSet", klog.KObj(preSts), "patch", patch)
+
+	sts := updateSts.DeepCopy()
+	sts.Labels = preSts.Labels
+	sts.Spec.Replicas = preSts.Spec.Replicas
+	sts.Spec.Template = preSts.Spec.Template
+	sts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+		Type: appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+			Partition: ptr.To(*preSts.Spec.Replicas),
+		},
+	}
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		storage := &appsv1.StatefulSet{}
+		_ = a.Client.Get(ctx, client.ObjectKeyFromObject(sts), storage)
+		sts.ResourceVersion = storage.ResourceVersion
+		return a.Handler.Update(ctx, sts)
+	}); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update statefulSet")}
+	}
+	_ = a.updateEMQXStatus(ctx, instance, "UpdateStatefulSetInPlace", "Update statefulSet in place", preSts.Labels[appsv2beta1.LabelsPodTemplateHashKey])
+	return subResult{}
+}
+
 func (a *addCore) updateEMQXStatus(ctx context.Context, instance *appsv2beta1.EMQX, reason, message, podTemplateHash string) error {
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		_ = a.Client.Get(ctx, client.ObjectKeyFromObject(instance), instance)
@@ -108,6 +211,11 @@ func (a *addCore) updateEMQXStatus(ctx context.Context, instance *appsv2beta1.EM
 		instance.Status.RemoveCondition(appsv2beta1.Ready)
 		instance.Status.RemoveCondition(appsv2beta1.Available)
 		instance.Status.RemoveCondition(appsv2beta1.CoreNodesReady)
+		if podTemplateHash != instance.Status.CoreNodesStatus.CurrentRevision {
+			// A new revision is rolling out, the previous rollback and analysis are no longer relevant
+			instance.Status.RemoveCondition(appsv2beta1.RollbackTriggered)
+			instance.Status.RemoveCondition(appsv2beta1.AnalysisFailed)
+		}
 		instance.Status.CoreNodesStatus.UpdateRevision = podTemplateHash
 		return a.Client.Status().Update(ctx, instance)
 	})
@@ -117,15 +225,39 @@ func getNewStatefulSet(instance *appsv2beta1.EMQX) *appsv1.StatefulSet {
 	svcPorts, _ := appsv2beta1.GetDashboardServicePort(instance.Spec.Config.Data)
 
 	preSts := generateStatefulSet(instance)
+	if instance.Spec.Config.RollOnReadOnlyChange {
+		preSts.Spec.Template.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Spec.Template.Annotations, appsv2beta1.AnnotationsReadOnlyConfigHashKey, computeReadOnlyConfigHash(instance))
+	}
+	addListenersTLSVolumes(instance, &preSts.Spec.Template.Spec)
+	addTLSVolumes(instance, &preSts.Spec.Template.Spec)
+	addNodeCookieRotation(instance, &preSts.Spec.Template)
+	if roleConfig := getRoleConfig(instance.Spec.CoreTemplate.Config); roleConfig != "" {
+		preSts.Spec.Template.Annotations = appsv2beta1.CloneAndAddLabel(preSts.Spec.Template.Annotations, appsv2beta1.AnnotationsRoleConfigHashKey, computeStringHash(roleConfig))
+	}
 	podTemplateSpecHash := computeHash(preSts.Spec.Template.DeepCopy(), instance.Status.CoreNodesStatus.CollisionCount)
-	preSts.Name = preSts.Name + "-" + podTemplateSpecHash
+	if isInPlaceUpdate(instance) {
+		// Keep the same statefulSet name and selector for every pod template change, so the pods and PVCs are reused.
+		// The pods are updated one by one by moving the partition, see syncPods.
+		preSts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+			Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+				Partition: ptr.To(int32(0)),
+			},
+		}
+	} else {
+		preSts.Name = preSts.Name + "-" + podTemplateSpecHash
+		preSts.Spec.Selector = appsv2beta1.CloneSelectorAndAddLabel(preSts.Spec.Selector, appsv2beta1.LabelsPodTemplateHashKey, podTemplateSpecHash)
+	}
 	preSts.Labels = appsv2beta1.CloneAndAddLabel(preSts.Labels, appsv2beta1.LabelsPodTemplateHashKey, podTemplateSpecHash)
-	preSts.Spec.Selector = appsv2beta1.CloneSelectorAndAddLabel(preSts.Spec.Selector, appsv2beta1.LabelsPodTemplateHashKey, podTemplateSpecHash)
 	preSts.Spec.Template.Labels = appsv2beta1.CloneAndAddLabel(preSts.Spec.Template.Labels, appsv2beta1.LabelsPodTemplateHashKey, podTemplateSpecHash)
 	preSts.Spec.Template.Spec.Containers[0].Ports = appsv2beta1.MergeContainerPorts(
 		preSts.Spec.Template.Spec.Containers[0].Ports,
 		appsv2beta1.TransServicePortsToContainerPorts(svcPorts),
 	)
+	preSts.Spec.Template.Spec.Containers[0].Ports = appsv2beta1.MergeContainerPorts(
+		preSts.Spec.Template.Spec.Containers[0].Ports,
+		getListenersContainerPorts(instance),
+	)
 	for _, p := range preSts.Spec.Template.Spec.Containers[0].Ports {
 		if p.Name == "dashboard" {
 			preSts.Spec.Template.Spec.Containers[0].Env = append([]corev1.EnvVar{
@@ -147,6 +279,11 @@ func generateStatefulSet(instance *appsv2beta1.EMQX) *appsv1.StatefulSet {
 		instance.Spec.CoreTemplate.Labels,
 	)
 
+	configsName := instance.ConfigsNamespacedName().Name
+	if getRoleConfig(instance.Spec.CoreTemplate.Config) != "" {
+		configsName = instance.CoreConfigsNamespacedName().Name
+	}
+
 	sts := &appsv1.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "apps/v1",
@@ -291,7 +428,7 @@ func generateStatefulSet(instance *appsv2beta1.EMQX) *appsv1.StatefulSet {
 							VolumeSource: corev1.VolumeSource{
 								ConfigMap: &corev1.ConfigMapVolumeSource{
 									LocalObjectReference: corev1.LocalObjectReference{
-										Name: instance.ConfigsNamespacedName().Name,
+										Name: configsName,
 									},
 								},
 							},
@@ -5,6 +5,7 @@ import (
 
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -75,6 +76,18 @@ func TestGetNewStatefulSet(t *testing.T) {
 		}, got.Spec.Selector.MatchLabels)
 	})
 
+	t.Run("check in place update mode", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.UpdateStrategy.CoreUpdateMode = appsv2beta1.CoreUpdateModeInPlace
+		got := getNewStatefulSet(emqx)
+		assert.Equal(t, "emqx-core", got.Name)
+		assert.NotEmpty(t, got.Labels[appsv2beta1.LabelsPodTemplateHashKey])
+		assert.Equal(t, got.Labels[appsv2beta1.LabelsPodTemplateHashKey], got.Spec.Template.Labels[appsv2beta1.LabelsPodTemplateHashKey])
+		assert.NotContains(t, got.Spec.Selector.MatchLabels, appsv2beta1.LabelsPodTemplateHashKey)
+		assert.Equal(t, appsv1.RollingUpdateStatefulSetStrategyType, got.Spec.UpdateStrategy.Type)
+		assert.Equal(t, ptr.To(int32(0)), got.Spec.UpdateStrategy.RollingUpdate.Partition)
+	})
+
 	t.Run("check http port", func(t *testing.T) {
 		emqx := instance.DeepCopy()
 		emqx.Spec.Config.Data = "dashboard.listeners.http.bind = 18083"
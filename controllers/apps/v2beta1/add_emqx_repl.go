@@ -35,7 +35,11 @@ func (a *addRepl) reconcile(ctx context.Context, logger logr.Logger, instance *a
 
 	preRs := getNewReplicaSet(instance)
 	preRsHash := preRs.Labels[appsv2beta1.LabelsPodTemplateHashKey]
-	updateRs, _, _ := getReplicaSetList(ctx, a.Client, instance)
+	updateRs, currentRs, oldRsList := getReplicaSetList(ctx, a.Client, instance)
+	if isRollingUpdate(instance) && appsv2beta1.IsExistReplicant(instance) && currentRs != nil && currentRs.Name != preRs.Name {
+		// The old replicaSet is still scaling down, so the new replicaSet only can grow up to maxSurge
+		preRs.Spec.Replicas = ptr.To(getRollingUpdateReplicas(instance, *currentRs.Spec.Replicas))
+	}
 
 	patchCalculateFunc := func(storage, new *appsv1.ReplicaSet) *patch.PatchResult {
 		if storage == nil {
@@ -50,19 +54,30 @@ func (a *addRepl) reconcile(ctx context.Context, logger logr.Logger, instance *a
 	}
 
 	if patchResult := patchCalculateFunc(updateRs, preRs); !patchResult.IsEmpty() {
+		if _, condition := instance.Status.GetCondition(appsv2beta1.UpgradePathSupported); condition != nil && condition.Status == metav1.ConditionFalse {
+			// The image of the EMQX spec can not be rolled out, see addCore
+			return subResult{}
+		}
 		//Crete Rs
 		logger.Info("got different pod template for EMQX replicant nodes, will create new replicaSet", "replicaSet", klog.KObj(preRs), "patch", string(patchResult.Patch))
 
+		preRs.Annotations = appsv2beta1.CloneAndAddLabel(preRs.Annotations, appsv2beta1.AnnotationsRevisionSpecKey, generateRevisionSpec(instance))
+		preRs.Annotations = appsv2beta1.CloneAndAddLabel(preRs.Annotations, appsv2beta1.AnnotationsRevisionConfigHashKey, computeStringHash(instance.Spec.Config.Data))
 		_ = ctrl.SetControllerReference(instance, preRs, a.Scheme)
 		if err := a.Handler.Create(ctx, preRs); err != nil {
 			if k8sErrors.IsAlreadyExists(emperror.Cause(err)) {
-				cond := instance.Status.GetLastTrueCondition()
-				if cond != nil && cond.Type != appsv2beta1.Available && cond.Type != appsv2beta1.Ready {
-					// Sometimes the updated replicaSet will not be ready, because the EMQX node can not be started.
-					// And then we will rollback EMQX CR spec, the EMQX operator controller will create a new replicaSet.
-					// But the new replicaSet will be the same as the previous one, so we didn't need to create it, just change the EMQX status.
-					if preRsHash == instance.Status.ReplicantNodesStatus.CurrentRevision {
-						_ = a.updateEMQXStatus(ctx, instance, "RevertReplicaSet", "Revert to current replicaSet", preRsHash)
+				// Sometimes the updated replicaSet will not be ready, because the EMQX node can not be started,
+				// or the new EMQX nodes regress in the update analysis.
+				// And then we will rollback EMQX CR spec, the EMQX operator controller will create a new replicaSet.
+				// But the new replicaSet will be the same as the previous one, so we didn't need to create it, just change the EMQX status.
+				if preRsHash == instance.Status.ReplicantNodesStatus.CurrentRevision {
+					_ = a.updateEMQXStatus(ctx, instance, "RevertReplicaSet", "Revert to current replicaSet", preRsHash)
+					return subResult{}
+				}
+				for _, rs := range oldRsList {
+					// Rollback to a retained revision, just scale the retained replicaSet up again.
+					if rs.Name == preRs.Name && patchCalculateFunc(rs, preRs).IsEmpty() {
+						_ = a.updateEMQXStatus(ctx, instance, "RollbackReplicaSet", "Rollback to retained replicaSet", preRsHash)
 						return subResult{}
 					}
 				}
@@ -98,6 +113,11 @@ func (a *addRepl) reconcile(ctx context.Context, logger logr.Logger, instance *a
 		}); err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to update replicaSet")}
 		}
+		if isRollingUpdateStep(instance, updateRs, currentRs) {
+			// The update replicaSet grows by a step of the same rollout, the cluster is still available,
+			// so InitialDelaySeconds is not applied again.
+			return subResult{}
+		}
 		_ = a.updateEMQXStatus(ctx, instance, "UpdateReplicaSet", "Update exist replicaSet", preRsHash)
 	}
 	return subResult{}
@@ -115,6 +135,11 @@ func (a *addRepl) updateEMQXStatus(ctx context.Context, instance *appsv2beta1.EM
 		instance.Status.RemoveCondition(appsv2beta1.Ready)
 		instance.Status.RemoveCondition(appsv2beta1.Available)
 		instance.Status.RemoveCondition(appsv2beta1.ReplicantNodesReady)
+		if podTemplateHash != instance.Status.ReplicantNodesStatus.CurrentRevision {
+			// A new revision is rolling out, the previous rollback and analysis are no longer relevant
+			instance.Status.RemoveCondition(appsv2beta1.RollbackTriggered)
+			instance.Status.RemoveCondition(appsv2beta1.AnalysisFailed)
+		}
 		instance.Status.ReplicantNodesStatus.UpdateRevision = podTemplateHash
 		return a.Client.Status().Update(ctx, instance)
 	})
@@ -124,6 +149,15 @@ func getNewReplicaSet(instance *appsv2beta1.EMQX) *appsv1.ReplicaSet {
 	svcPorts, _ := appsv2beta1.GetDashboardServicePort(instance.Spec.Config.Data)
 
 	preRs := generateReplicaSet(instance)
+	if instance.Spec.Config.RollOnReadOnlyChange {
+		preRs.Spec.Template.Annotations = appsv2beta1.CloneAndAddLabel(preRs.Spec.Template.Annotations, appsv2beta1.AnnotationsReadOnlyConfigHashKey, computeReadOnlyConfigHash(instance))
+	}
+	addListenersTLSVolumes(instance, &preRs.Spec.Template.Spec)
+	addTLSVolumes(instance, &preRs.Spec.Template.Spec)
+	addNodeCookieRotation(instance, &preRs.Spec.Template)
+	if roleConfig := getRoleConfig(instance.Spec.ReplicantTemplate.Config); roleConfig != "" {
+		preRs.Spec.Template.Annotations = appsv2beta1.CloneAndAddLabel(preRs.Spec.Template.Annotations, appsv2beta1.AnnotationsRoleConfigHashKey, computeStringHash(roleConfig))
+	}
 	podTemplateSpecHash := computeHash(preRs.Spec.Template.DeepCopy(), instance.Status.ReplicantNodesStatus.CollisionCount)
 	preRs.Name = preRs.Name + "-" + podTemplateSpecHash
 	preRs.Labels = appsv2beta1.CloneAndAddLabel(preRs.Labels, appsv2beta1.LabelsPodTemplateHashKey, podTemplateSpecHash)
@@ -133,6 +167,10 @@ func getNewReplicaSet(instance *appsv2beta1.EMQX) *appsv1.ReplicaSet {
 		preRs.Spec.Template.Spec.Containers[0].Ports,
 		appsv2beta1.TransServicePortsToContainerPorts(svcPorts),
 	)
+	preRs.Spec.Template.Spec.Containers[0].Ports = appsv2beta1.MergeContainerPorts(
+		preRs.Spec.Template.Spec.Containers[0].Ports,
+		getListenersContainerPorts(instance),
+	)
 	for _, p := range preRs.Spec.Template.Spec.Containers[0].Ports {
 		if p.Name == "dashboard" {
 			preRs.Spec.Template.Spec.Containers[0].Env = append([]corev1.EnvVar{
@@ -155,6 +193,11 @@ func generateReplicaSet(instance *appsv2beta1.EMQX) *appsv1.ReplicaSet {
 		instance.Spec.ReplicantTemplate.Labels,
 	)
 
+	configsName := instance.ConfigsNamespacedName().Name
+	if getRoleConfig(instance.Spec.ReplicantTemplate.Config) != "" {
+		configsName = instance.ReplicantConfigsNamespacedName().Name
+	}
+
 	return &appsv1.ReplicaSet{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ReplicaSet",
@@ -293,7 +336,7 @@ func generateReplicaSet(instance *appsv2beta1.EMQX) *appsv1.ReplicaSet {
 							VolumeSource: corev1.VolumeSource{
 								ConfigMap: &corev1.ConfigMapVolumeSource{
 									LocalObjectReference: corev1.LocalObjectReference{
-										Name: instance.ConfigsNamespacedName().Name,
+										Name: configsName,
 									},
 								},
 							},
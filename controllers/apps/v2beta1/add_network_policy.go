@@ -0,0 +1,285 @@
+package v2beta1
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	"github.com/rory-z/go-hocon"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+type addNetworkPolicy struct {
+	*EMQXReconciler
+}
+
+func (a *addNetworkPolicy) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, _ innerReq.RequesterInterface) subResult {
+	if instance.Spec.NetworkPolicy == nil {
+		// Delete the NetworkPolicy generated before .spec.networkPolicy was removed
+		policy := &networkingv1.NetworkPolicy{}
+		if err := a.Client.Get(ctx, instance.NetworkPolicyNamespacedName(), policy); err != nil {
+			if k8sErrors.IsNotFound(err) {
+				return subResult{}
+			}
+			return subResult{err: emperror.Wrap(err, "failed to get network policy")}
+		}
+		if !metav1.IsControlledBy(policy, instance) {
+			return subResult{}
+		}
+		if err := a.Client.Delete(ctx, policy); err != nil && !k8sErrors.IsNotFound(err) {
+			return subResult{err: emperror.Wrap(err, "failed to delete network policy")}
+		}
+		return subResult{}
+	}
+
+	config, _, _, err := a.renderEMQXConfig(ctx, instance)
+	if err != nil {
+		return subResult{err: err}
+	}
+	listenerPorts, err := a.getListenerServicesPorts(ctx, instance)
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to get the ports of the listener services")}
+	}
+	policy := generateNetworkPolicy(instance, config, getOperatorNamespace(), listenerPorts)
+	if err := a.CreateOrUpdateList(ctx, a.Scheme, logger, instance, []client.Object{policy}); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to create or update network policy")}
+	}
+	return subResult{}
+}
+
+// getListenerServicesPorts returns the target ports of the listeners service and the services named in .spec.listeners[].service.name,
+// the services not created yet are skipped.
+func (a *addNetworkPolicy) getListenerServicesPorts(ctx context.Context, instance *appsv2beta1.EMQX) ([]corev1.ServicePort, error) {
+	names := []string{instance.ListenersServiceNamespacedName().Name}
+	for _, svc := range generateListenerDedicatedServices(instance) {
+		names = append(names, svc.Name)
+	}
+
+	ports := []corev1.ServicePort{}
+	for _, name := range names {
+		svc := &corev1.Service{}
+		if err := a.Client.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, svc); err != nil {
+			if k8sErrors.IsNotFound(err) {
+				continue
+			}
+			return nil, emperror.Wrapf(err, "failed to get service %s", name)
+		}
+		ports = append(ports, svc.Spec.Ports...)
+	}
+	return ports, nil
+}
+
+// generateNetworkPolicy generates the NetworkPolicy that allows the Erlang distribution and gen_rpc ports between the EMQX nodes,
+// the dashboard ports from the operator namespace and .spec.networkPolicy.dashboardCIDRs, and the ports of the listener services.
+func generateNetworkPolicy(instance *appsv2beta1.EMQX, config, operatorNamespace string, listenerPorts []corev1.ServicePort) *networkingv1.NetworkPolicy {
+	rules := []networkingv1.NetworkPolicyIngressRule{
+		{
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: appsv2beta1.DefaultLabels(instance),
+					},
+				},
+			},
+			Ports: getClusterNetworkPolicyPorts(instance, config),
+		},
+	}
+
+	if dashboardPorts := getDashboardNetworkPolicyPorts(instance); len(dashboardPorts) > 0 {
+		from := []networkingv1.NetworkPolicyPeer{}
+		if operatorNamespace != "" {
+			from = append(from, networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: operatorNamespace},
+				},
+			})
+		}
+		for _, cidr := range instance.Spec.NetworkPolicy.DashboardCIDRs {
+			from = append(from, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+		// An empty from allows all sources, so the rule is skipped if there is no allowed source
+		if len(from) > 0 {
+			rules = append(rules, networkingv1.NetworkPolicyIngressRule{From: from, Ports: dashboardPorts})
+		}
+	}
+
+	// An empty ports allows all ports, so the rule is skipped if there is no listener port
+	if ports := getListenerNetworkPolicyPorts(listenerPorts); len(ports) > 0 {
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{Ports: ports})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: instance.Namespace,
+			Name:      instance.NetworkPolicyNamespacedName().Name,
+			Labels:    appsv2beta1.CloneAndMergeMap(appsv2beta1.DefaultLabels(instance), instance.Labels),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: appsv2beta1.DefaultLabels(instance),
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+}
+
+// getClusterNetworkPolicyPorts returns the Erlang distribution and gen_rpc ports of the EMQX nodes,
+// read from the EMQX config, the config of the EMQX nodes role and the EMQX_ environment variables.
+func getClusterNetworkPolicyPorts(instance *appsv2beta1.EMQX, config string) []networkingv1.NetworkPolicyPort {
+	coreConfig := config
+	if instance.Spec.CoreTemplate.Config != nil {
+		coreConfig += "\n" + instance.Spec.CoreTemplate.Config.Data
+	}
+	ports := getNodeClusterPorts(coreConfig, instance.Spec.CoreTemplate.Spec.Env)
+	if appsv2beta1.IsExistReplicant(instance) {
+		replicantConfig := config
+		if instance.Spec.ReplicantTemplate.Config != nil {
+			replicantConfig += "\n" + instance.Spec.ReplicantTemplate.Config.Data
+		}
+		ports = append(ports, getNodeClusterPorts(replicantConfig, instance.Spec.ReplicantTemplate.Spec.Env)...)
+	}
+
+	policyPorts := []networkingv1.NetworkPolicyPort{}
+	seen := map[[2]int32]bool{}
+	for _, port := range ports {
+		if seen[port] {
+			continue
+		}
+		seen[port] = true
+		policyPort := networkingv1.NetworkPolicyPort{
+			Protocol: ptr.To(corev1.ProtocolTCP),
+			Port:     ptr.To(intstr.FromInt(int(port[0]))),
+		}
+		if port[1] > port[0] {
+			policyPort.EndPort = ptr.To(port[1])
+		}
+		policyPorts = append(policyPorts, policyPort)
+	}
+	sort.Slice(policyPorts, func(i, j int) bool {
+		return policyPorts[i].Port.IntVal < policyPorts[j].Port.IntVal
+	})
+	return policyPorts
+}
+
+// getNodeClusterPorts returns the port ranges of the Erlang distribution and gen_rpc of the EMQX nodes,
+// the EMQX_ environment variables override the EMQX config.
+func getNodeClusterPorts(config string, env []corev1.EnvVar) [][2]int32 {
+	values := map[string]int32{
+		"node.dist_listen_min": 4370,
+		"rpc.tcp_server_port":  5369,
+		"rpc.ssl_server_port":  5369,
+	}
+	if hoconConfig, err := hocon.ParseString(config); err == nil {
+		if root, ok := hoconConfig.GetRoot().(hocon.Object); ok {
+			for _, path := range []string{"node.dist_listen_min", "node.dist_listen_max", "rpc.tcp_server_port", "rpc.ssl_server_port"} {
+				keys := strings.Split(path, ".")
+				sub, ok := root[keys[0]].(hocon.Object)
+				if !ok || sub[keys[1]] == nil {
+					continue
+				}
+				if port, err := strconv.Atoi(strings.Trim(sub[keys[1]].String(), `"`)); err == nil {
+					values[path] = int32(port)
+				}
+			}
+		}
+	}
+	for _, e := range env {
+		path, ok := appsv2beta1.GetEnvConfigPath(e.Name)
+		if !ok {
+			continue
+		}
+		if _, ok := values[path]; !ok && path != "node.dist_listen_max" {
+			continue
+		}
+		if port, err := strconv.Atoi(e.Value); err == nil {
+			values[path] = int32(port)
+		}
+	}
+
+	distMax, ok := values["node.dist_listen_max"]
+	if !ok || distMax < values["node.dist_listen_min"] {
+		distMax = values["node.dist_listen_min"]
+	}
+	return [][2]int32{
+		{values["node.dist_listen_min"], distMax},
+		{values["rpc.tcp_server_port"], values["rpc.tcp_server_port"]},
+		{values["rpc.ssl_server_port"], values["rpc.ssl_server_port"]},
+	}
+}
+
+func getDashboardNetworkPolicyPorts(instance *appsv2beta1.EMQX) []networkingv1.NetworkPolicyPort {
+	portMap, _ := appsv2beta1.GetDashboardPortMap(instance.Spec.Config.Data)
+	names := []string{}
+	for name := range portMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ports := []networkingv1.NetworkPolicyPort{}
+	for _, name := range names {
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: ptr.To(corev1.ProtocolTCP),
+			Port:     ptr.To(intstr.FromInt(int(portMap[name]))),
+		})
+	}
+	return ports
+}
+
+// getListenerNetworkPolicyPorts returns the target ports of the service ports without duplicates
+func getListenerNetworkPolicyPorts(svcPorts []corev1.ServicePort) []networkingv1.NetworkPolicyPort {
+	ports := []networkingv1.NetworkPolicyPort{}
+	seen := map[string]bool{}
+	for _, svcPort := range svcPorts {
+		target := svcPort.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt(int(svcPort.Port))
+		}
+		protocol := svcPort.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		key := string(protocol) + "/" + target.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: ptr.To(protocol),
+			Port:     ptr.To(target),
+		})
+	}
+	return ports
+}
+
+// getOperatorNamespace returns the namespace of the operator pod, or an empty string if the operator is not running in a pod.
+func getOperatorNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return ""
+}
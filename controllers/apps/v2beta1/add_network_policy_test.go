@@ -0,0 +1,154 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestGenerateNetworkPolicy(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.NetworkPolicy = &appsv2beta1.NetworkPolicy{}
+
+	t.Run("default", func(t *testing.T) {
+		got := generateNetworkPolicy(instance, "", "", nil)
+		assert.Equal(t, "emqx-network-policy", got.Name)
+		assert.Equal(t, appsv2beta1.DefaultLabels(instance), got.Spec.PodSelector.MatchLabels)
+		assert.Equal(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}, got.Spec.PolicyTypes)
+		// Only the Erlang distribution and gen_rpc ports between the EMQX nodes are allowed
+		assert.Equal(t, []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: appsv2beta1.DefaultLabels(instance)}},
+				},
+				Ports: []networkingv1.NetworkPolicyPort{
+					{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(4370))},
+					{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(5369))},
+				},
+			},
+		}, got.Spec.Ingress)
+	})
+
+	t.Run("cluster ports", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.ReplicantTemplate = &appsv2beta1.EMQXReplicantTemplate{
+			Spec: appsv2beta1.EMQXReplicantTemplateSpec{
+				Replicas: ptr.To(int32(2)),
+				Env:      []corev1.EnvVar{{Name: "EMQX_RPC__TCP_SERVER_PORT", Value: "5370"}},
+			},
+		}
+		config := "node.dist_listen_min = 4380\nnode.dist_listen_max = 4390\nrpc.tcp_server_port = 5380"
+		got := generateNetworkPolicy(emqx, config, "", nil)
+		assert.Equal(t, []networkingv1.NetworkPolicyPort{
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(4380)), EndPort: ptr.To(int32(4390))},
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(5369))},
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(5370))},
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(5380))},
+		}, got.Spec.Ingress[0].Ports)
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.Config.Data = "dashboard.listeners.https.bind = 18084"
+		emqx.Spec.NetworkPolicy.DashboardCIDRs = []string{"10.0.0.0/8"}
+		got := generateNetworkPolicy(emqx, emqx.Spec.Config.Data, "emqx-operator-system", nil)
+		assert.Len(t, got.Spec.Ingress, 2)
+		assert.Equal(t, networkingv1.NetworkPolicyIngressRule{
+			From: []networkingv1.NetworkPolicyPeer{
+				{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "emqx-operator-system"}}},
+				{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(18083))},
+				{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(18084))},
+			},
+		}, got.Spec.Ingress[1])
+	})
+
+	t.Run("listeners", func(t *testing.T) {
+		got := generateNetworkPolicy(instance, "", "", []corev1.ServicePort{
+			{Name: "tcp-default", Port: 1883, TargetPort: intstr.FromInt(1883)},
+			{Name: "ssl-default", Port: 8883},
+			{Name: "quic-default", Port: 14567, Protocol: corev1.ProtocolUDP, TargetPort: intstr.FromString("quic-default")},
+			{Name: "tcp-default", Port: 1883, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(1883)},
+		})
+		assert.Len(t, got.Spec.Ingress, 2)
+		// The listener ports are allowed from all sources
+		assert.Nil(t, got.Spec.Ingress[1].From)
+		assert.Equal(t, []networkingv1.NetworkPolicyPort{
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(1883))},
+			{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt(8883))},
+			{Protocol: ptr.To(corev1.ProtocolUDP), Port: ptr.To(intstr.FromString("quic-default"))},
+		}, got.Spec.Ingress[1].Ports)
+	})
+}
+
+func TestAddNetworkPolicy(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "fake-uid"},
+	}
+	instance.Spec.NetworkPolicy = &appsv2beta1.NetworkPolicy{}
+	instance.Spec.Listeners = []appsv2beta1.Listener{
+		{Type: "tcp", Name: "default", Port: 1883},
+		{Type: "ws", Name: "default", Port: 8083, Service: &appsv2beta1.ListenerService{Name: "ws"}},
+	}
+	listeners := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx-listeners", Namespace: "emqx"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "tcp-default", Port: 1883, TargetPort: intstr.FromInt(1883)}},
+		},
+	}
+	policy := generateNetworkPolicy(instance, "", "", nil)
+
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := networkingv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.SetControllerReference(instance, policy, scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, listeners, policy).Build()
+	a := &addNetworkPolicy{&EMQXReconciler{
+		Handler:       &handler.Handler{Client: k8sClient},
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(10),
+	}}
+	ctx := context.Background()
+
+	// The dedicated listener service is not created yet
+	ports, err := a.getListenerServicesPorts(ctx, instance)
+	assert.Nil(t, err)
+	assert.Equal(t, listeners.Spec.Ports, ports)
+
+	// The NetworkPolicy is deleted once .spec.networkPolicy is removed
+	instance.Spec.NetworkPolicy = nil
+	result := a.reconcile(ctx, log.FromContext(ctx), instance, nil)
+	assert.Nil(t, result.err)
+	err = k8sClient.Get(ctx, instance.NetworkPolicyNamespacedName(), &networkingv1.NetworkPolicy{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+
+	result = a.reconcile(ctx, log.FromContext(ctx), instance, nil)
+	assert.Nil(t, result.err)
+}
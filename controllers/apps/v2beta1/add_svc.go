@@ -3,12 +3,14 @@ package v2beta1
 import (
 	"context"
 	"net/http"
+	"slices"
 
 	emperror "emperror.dev/errors"
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -33,19 +35,48 @@ func (a *addSvc) reconcile(ctx context.Context, logger logr.Logger, instance *ap
 	}
 
 	resources := []client.Object{}
+	dedicatedServices := generateListenerDedicatedServices(instance)
 	if dashboard := generateDashboardService(instance, configStr); dashboard != nil {
 		resources = append(resources, dashboard)
 	}
 	if listeners := generateListenerService(instance, configStr); listeners != nil {
 		resources = append(resources, listeners)
 	}
+	for _, svc := range dedicatedServices {
+		resources = append(resources, svc)
+	}
 
 	if err := a.CreateOrUpdateList(ctx, a.Scheme, logger, instance, resources); err != nil {
 		return subResult{err: emperror.Wrap(err, "failed to create or update services")}
 	}
+	if err := a.pruneListenerDedicatedServices(ctx, instance, dedicatedServices); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to prune listener services")}
+	}
 	return subResult{}
 }
 
+// pruneListenerDedicatedServices deletes the services of the listeners that are renamed or removed from .spec.listeners
+func (a *addSvc) pruneListenerDedicatedServices(ctx context.Context, instance *appsv2beta1.EMQX, services []*corev1.Service) error {
+	list := &corev1.ServiceList{}
+	if err := a.Client.List(ctx, list,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultLabels(instance)),
+		client.HasLabels{appsv2beta1.LabelsListenerServiceKey},
+	); err != nil {
+		return emperror.Wrap(err, "failed to list services")
+	}
+	for i := range list.Items {
+		svc := &list.Items[i]
+		if !metav1.IsControlledBy(svc, instance) || slices.ContainsFunc(services, func(s *corev1.Service) bool { return s.Name == svc.Name }) {
+			continue
+		}
+		if err := a.Client.Delete(ctx, svc); err != nil && !k8sErrors.IsNotFound(err) {
+			return emperror.Wrapf(err, "failed to delete service %s", svc.Name)
+		}
+	}
+	return nil
+}
+
 func (a *addSvc) getEMQXConfigsByAPI(r innerReq.RequesterInterface) (string, error) {
 	url := r.GetURL("api/v5/configs")
 
@@ -104,6 +135,21 @@ func generateListenerService(instance *appsv2beta1.EMQX, configStr string) *core
 		svc.Spec = *instance.Spec.ListenersServiceTemplate.Spec.DeepCopy()
 	}
 
+	if len(instance.Spec.Listeners) > 0 {
+		ports, annotations := getListenersServicePortsBySpec(instance, "")
+		if len(ports) == 0 {
+			return nil
+		}
+		if svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			for i := range ports {
+				ports[i].NodePort = 0
+			}
+		}
+		svc.Spec.Ports = appsv2beta1.MergeServicePorts(svc.Spec.Ports, ports)
+		svc.ObjectMeta.Annotations = appsv2beta1.CloneAndMergeMap(svc.ObjectMeta.Annotations, annotations)
+		return generateListenerServiceObject(instance, svc)
+	}
+
 	ports, _ := appsv2beta1.GetListenersServicePorts(configStr)
 	if len(ports) == 0 {
 		ports = append(ports, []corev1.ServicePort{
@@ -138,6 +184,10 @@ func generateListenerService(instance *appsv2beta1.EMQX, configStr string) *core
 		svc.Spec.Ports,
 		ports,
 	)
+	return generateListenerServiceObject(instance, svc)
+}
+
+func generateListenerServiceObject(instance *appsv2beta1.EMQX, svc *corev1.Service) *corev1.Service {
 	svc.Spec.Selector = appsv2beta1.DefaultCoreLabels(instance)
 	if appsv2beta1.IsExistReplicant(instance) && instance.Status.ReplicantNodesStatus.ReadyReplicas > 0 {
 		svc.Spec.Selector = appsv2beta1.DefaultReplicantLabels(instance)
@@ -1,14 +1,19 @@
 package v2beta1
 
 import (
+	"context"
 	"testing"
 
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestGenerateHeadlessSVC(t *testing.T) {
@@ -180,3 +185,50 @@ func TestGenerateDashboardService(t *testing.T) {
 		assert.Nil(t, got)
 	})
 }
+
+func TestPruneListenerDedicatedServices(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "fake-uid"},
+	}
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	newService := func(name string, controlled bool) *corev1.Service {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      instance.ListenerServiceNamespacedName(name).Name,
+				Namespace: "emqx",
+				Labels:    appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultLabels(instance), appsv2beta1.LabelsListenerServiceKey, name),
+			},
+		}
+		if controlled {
+			_ = ctrl.SetControllerReference(instance, svc, scheme)
+		}
+		return svc
+	}
+	current := newService("ws", true)
+	stale := newService("mqtt", true)
+	// The services not controlled by the EMQX are kept
+	other := newService("other", false)
+	// The other services of the EMQX are kept
+	listeners := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx-listeners", Namespace: "emqx", Labels: appsv2beta1.DefaultLabels(instance)},
+	}
+	_ = ctrl.SetControllerReference(instance, listeners, scheme)
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, current, stale, other, listeners).Build()
+	a := &addSvc{&EMQXReconciler{
+		Handler: &handler.Handler{Client: k8sClient},
+		Scheme:  scheme,
+	}}
+	assert.Nil(t, a.pruneListenerDedicatedServices(context.Background(), instance, []*corev1.Service{current}))
+
+	list := &corev1.ServiceList{}
+	assert.Nil(t, k8sClient.List(context.Background(), list))
+	names := []string{}
+	for _, svc := range list.Items {
+		names = append(names, svc.Name)
+	}
+	assert.ElementsMatch(t, []string{"emqx-ws", "emqx-other", "emqx-listeners"}, names)
+}
@@ -0,0 +1,126 @@
+package v2beta1
+
+import (
+	"fmt"
+	"strconv"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/tidwall/gjson"
+)
+
+// analyzeNodes compares the metrics of the new EMQX nodes with the old EMQX nodes,
+// and returns the regressions found by the analysis strategy.
+func analyzeNodes(analysis *appsv2beta1.AnalysisStrategy, metrics map[string]map[string]float64, alarms map[string]int32, newNodes, oldNodes []string) ([]string, error) {
+	regressions := []string{}
+
+	if analysis.MaxAlarms != nil {
+		for _, node := range newNodes {
+			if alarms[node] > *analysis.MaxAlarms {
+				regressions = append(regressions, fmt.Sprintf("node %s has %d activated alarms, more than %d", node, alarms[node], *analysis.MaxAlarms))
+			}
+		}
+	}
+
+	if len(newNodes) == 0 || len(oldNodes) == 0 {
+		return regressions, nil
+	}
+
+	for _, metric := range analysis.Metrics {
+		absThreshold, relThreshold := 0.0, 1.1
+		var err error
+		if metric.AbsThreshold != "" {
+			if absThreshold, err = strconv.ParseFloat(metric.AbsThreshold, 64); err != nil {
+				return nil, emperror.Wrapf(err, "failed to parse absThreshold of metric %s", metric.Name)
+			}
+		}
+		if metric.RelThreshold != "" {
+			if relThreshold, err = strconv.ParseFloat(metric.RelThreshold, 64); err != nil {
+				return nil, emperror.Wrapf(err, "failed to parse relThreshold of metric %s", metric.Name)
+			}
+		}
+
+		newValue := averageMetric(metrics, newNodes, metric)
+		oldValue := averageMetric(metrics, oldNodes, metric)
+		if newValue-oldValue > absThreshold && newValue > oldValue*relThreshold {
+			regressions = append(regressions, fmt.Sprintf("metric %s of the new nodes is %g, the old nodes is %g", metricName(metric), newValue, oldValue))
+		}
+	}
+	return regressions, nil
+}
+
+func averageMetric(metrics map[string]map[string]float64, nodes []string, metric appsv2beta1.AnalysisMetric) float64 {
+	var sum float64
+	var count int
+	for _, node := range nodes {
+		value := metrics[node][metric.Name]
+		if metric.DividedBy != "" {
+			divisor := metrics[node][metric.DividedBy]
+			if divisor == 0 {
+				continue
+			}
+			value = value / divisor
+		}
+		sum += value
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+func metricName(metric appsv2beta1.AnalysisMetric) string {
+	if metric.DividedBy != "" {
+		return metric.Name + "/" + metric.DividedBy
+	}
+	return metric.Name
+}
+
+// getNodeMetricsByAPI returns the metrics and stats of every EMQX node, grouped by the node name.
+func getNodeMetricsByAPI(r innerReq.RequesterInterface) (map[string]map[string]float64, error) {
+	metrics := map[string]map[string]float64{}
+	for _, path := range []string{"api/v5/metrics", "api/v5/stats"} {
+		url := r.GetURL(path, "aggregate=false")
+		resp, body, err := r.Request("GET", url, nil, nil)
+		if err != nil {
+			return nil, emperror.Wrapf(err, "failed to get API %s", url.String())
+		}
+		if resp.StatusCode != 200 {
+			return nil, emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+		}
+
+		for _, nodeMetrics := range gjson.ParseBytes(body).Array() {
+			node := nodeMetrics.Get("node").String()
+			if _, ok := metrics[node]; !ok {
+				metrics[node] = map[string]float64{}
+			}
+			nodeMetrics.ForEach(func(key, value gjson.Result) bool {
+				if value.Type == gjson.Number {
+					metrics[node][key.String()] = value.Float()
+				}
+				return true
+			})
+		}
+	}
+	return metrics, nil
+}
+
+// getActivatedAlarmsByAPI returns the number of activated alarms of every EMQX node.
+func getActivatedAlarmsByAPI(r innerReq.RequesterInterface) (map[string]int32, error) {
+	url := r.GetURL("api/v5/alarms", "activated=true", "limit=1000")
+	resp, body, err := r.Request("GET", url, nil, nil)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "failed to get API %s", url.String())
+	}
+	if resp.StatusCode != 200 {
+		return nil, emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+
+	alarms := map[string]int32{}
+	for _, alarm := range gjson.GetBytes(body, "data").Array() {
+		alarms[alarm.Get("node").String()]++
+	}
+	return alarms, nil
+}
@@ -0,0 +1,105 @@
+package v2beta1
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+func TestAnalyzeNodes(t *testing.T) {
+	metrics := map[string]map[string]float64{
+		"emqx@new": {"authentication.failure": 50, "authentication.success": 50, "messages.dropped": 10},
+		"emqx@old": {"authentication.failure": 10, "authentication.success": 990, "messages.dropped": 100},
+	}
+	newNodes, oldNodes := []string{"emqx@new"}, []string{"emqx@old"}
+
+	t.Run("no regression", func(t *testing.T) {
+		analysis := &appsv2beta1.AnalysisStrategy{
+			Metrics: []appsv2beta1.AnalysisMetric{
+				{Name: "messages.dropped", AbsThreshold: "0", RelThreshold: "1.1"},
+			},
+		}
+		got, err := analyzeNodes(analysis, metrics, nil, newNodes, oldNodes)
+		assert.Nil(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("regression of rate", func(t *testing.T) {
+		analysis := &appsv2beta1.AnalysisStrategy{
+			Metrics: []appsv2beta1.AnalysisMetric{
+				{Name: "authentication.failure", DividedBy: "authentication.success", AbsThreshold: "0.1", RelThreshold: "1.1"},
+			},
+		}
+		got, err := analyzeNodes(analysis, metrics, nil, newNodes, oldNodes)
+		assert.Nil(t, err)
+		assert.Len(t, got, 1)
+		assert.Contains(t, got[0], "authentication.failure/authentication.success")
+	})
+
+	t.Run("regression under absolute threshold", func(t *testing.T) {
+		analysis := &appsv2beta1.AnalysisStrategy{
+			Metrics: []appsv2beta1.AnalysisMetric{
+				{Name: "authentication.failure", AbsThreshold: "100", RelThreshold: "1.1"},
+			},
+		}
+		got, err := analyzeNodes(analysis, metrics, nil, newNodes, oldNodes)
+		assert.Nil(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("too many alarms", func(t *testing.T) {
+		analysis := &appsv2beta1.AnalysisStrategy{MaxAlarms: ptr.To(int32(0))}
+		got, err := analyzeNodes(analysis, metrics, map[string]int32{"emqx@new": 1, "emqx@old": 5}, newNodes, oldNodes)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"node emqx@new has 1 activated alarms, more than 0"}, got)
+	})
+
+	t.Run("invalid threshold", func(t *testing.T) {
+		analysis := &appsv2beta1.AnalysisStrategy{
+			Metrics: []appsv2beta1.AnalysisMetric{
+				{Name: "messages.dropped", RelThreshold: "fake"},
+			},
+		}
+		_, err := analyzeNodes(analysis, metrics, nil, newNodes, oldNodes)
+		assert.Error(t, err)
+	})
+}
+
+func TestGetNodeMetricsByAPI(t *testing.T) {
+	f := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			switch url.Path {
+			case "api/v5/metrics":
+				return &http.Response{StatusCode: http.StatusOK}, []byte(`[{"node":"emqx@a","messages.dropped":1},{"node":"emqx@b","messages.dropped":2}]`), nil
+			case "api/v5/stats":
+				return &http.Response{StatusCode: http.StatusOK}, []byte(`[{"node":"emqx@a","connections.count":10},{"node":"emqx@b","connections.count":20}]`), nil
+			}
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found"}, nil, nil
+		},
+	}
+
+	got, err := getNodeMetricsByAPI(f)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]float64{
+		"emqx@a": {"messages.dropped": 1, "connections.count": 10},
+		"emqx@b": {"messages.dropped": 2, "connections.count": 20},
+	}, got)
+}
+
+func TestGetActivatedAlarmsByAPI(t *testing.T) {
+	f := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			assert.Equal(t, "api/v5/alarms", url.Path)
+			return &http.Response{StatusCode: http.StatusOK}, []byte(`{"data":[{"node":"emqx@a","name":"high_cpu_usage"},{"node":"emqx@a","name":"high_system_memory_usage"}],"meta":{"count":2}}`), nil
+		},
+	}
+
+	got, err := getActivatedAlarmsByAPI(f)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int32{"emqx@a": 2}, got)
+}
@@ -0,0 +1,270 @@
+package v2beta1
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	emperror "emperror.dev/errors"
+	"github.com/rory-z/go-hocon"
+)
+
+// The sensitive config values are masked in the EMQX API response
+const maskedConfigValue = "******"
+
+var portRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+// diffConfig returns the sorted paths of the desired config whose values differ from the live config.
+// The config only in the live config is ignored, because EMQX returns all the config with the default values,
+// including the default values of the objects in the arrays, like the authenticators in `authentication`.
+func diffConfig(desired, live hocon.Object) []string {
+	paths := []string{}
+	for key, value := range desired {
+		paths = appendConfigDiff(paths, key, value, live[key])
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// appendConfigDiff appends the paths of the desired value that differ from the live value.
+// The objects are compared by the keys of the desired value, and the arrays are compared by the indexes of the elements,
+// an array with a different length from the live array is drifted as a whole.
+func appendConfigDiff(paths []string, path string, desired, live hocon.Value) []string {
+	switch d := desired.(type) {
+	case hocon.Object:
+		if len(d) > 0 {
+			l, _ := live.(hocon.Object)
+			for key, value := range d {
+				paths = appendConfigDiff(paths, path+"."+key, value, l[key])
+			}
+			return paths
+		}
+	case hocon.Array:
+		l, ok := live.(hocon.Array)
+		if !ok || len(l) != len(d) {
+			return append(paths, path)
+		}
+		for i := range d {
+			paths = appendConfigDiff(paths, path+"."+strconv.Itoa(i), d[i], l[i])
+		}
+		return paths
+	}
+	if !isConfigValueEqual(desired, live) {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// flattenConfig flattens the HOCON object to the leaf values by the dot separated paths.
+func flattenConfig(prefix string, obj hocon.Object, values map[string]hocon.Value) {
+	for key, value := range obj {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if sub, ok := value.(hocon.Object); ok && len(sub) > 0 {
+			flattenConfig(path, sub, values)
+			continue
+		}
+		values[path] = value
+	}
+}
+
+func isConfigValueEqual(desired, live hocon.Value) bool {
+	if live == nil {
+		return desired == nil
+	}
+	desiredStr, liveStr := configValueString(desired), configValueString(live)
+	if desiredStr == liveStr || liveStr == maskedConfigValue {
+		return true
+	}
+	// EMQX returns the bind address with the IP, like "0.0.0.0:1883" for 1883
+	if portRegexp.MatchString(desiredStr) && strings.HasSuffix(liveStr, ":"+desiredStr) {
+		return true
+	}
+	return false
+}
+
+// configValueString returns the string of the HOCON value, the keys of the objects are sorted.
+func configValueString(value hocon.Value) string {
+	switch v := value.(type) {
+	case hocon.Object:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, key+":"+configValueString(v[key]))
+		}
+		return "{" + strings.Join(items, ",") + "}"
+	case hocon.Array:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, configValueString(item))
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	case nil:
+		return ""
+	}
+	if value.Type() == hocon.ConcatenationType {
+		return strings.ReplaceAll(value.String(), `"`, "")
+	}
+	return strings.Trim(value.String(), `"`)
+}
+
+// diffConfigChanges returns the sorted leaf paths that are added, changed or removed from the old config to the new config.
+func diffConfigChanges(oldConf, newConf string) ([]string, error) {
+	oldConfig, err := hocon.ParseString(oldConf)
+	if err != nil {
+		return nil, emperror.Wrap(err, "failed to parse the old config")
+	}
+	newConfig, err := hocon.ParseString(newConf)
+	if err != nil {
+		return nil, emperror.Wrap(err, "failed to parse the new config")
+	}
+	oldValues := map[string]hocon.Value{}
+	flattenConfig("", oldConfig.GetRoot().(hocon.Object), oldValues)
+	newValues := map[string]hocon.Value{}
+	flattenConfig("", newConfig.GetRoot().(hocon.Object), newValues)
+
+	paths := []string{}
+	for path, value := range newValues {
+		if old, ok := oldValues[path]; !ok || configValueString(old) != configValueString(value) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range oldValues {
+		if _, ok := newValues[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// getConfigRoots returns the sorted root keys of the config paths.
+func getConfigRoots(paths []string) []string {
+	roots := []string{}
+	for _, path := range paths {
+		root, _, _ := strings.Cut(path, ".")
+		if !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// getRemovedConfigRoots returns the root keys which are not in the config.
+func getRemovedConfigRoots(config string, roots []string) []string {
+	hoconConfig, err := hocon.ParseString(config)
+	if err != nil {
+		return nil
+	}
+	root := hoconConfig.GetRoot().(hocon.Object)
+
+	removed := []string{}
+	for _, key := range roots {
+		if _, ok := root[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	return removed
+}
+
+// renderConfigRoots renders the root keys of the config into HOCON.
+// It returns an error if a root key is removed from the config, or the rendered config is not the same as the config,
+// so the caller can fall back to the whole config.
+func renderConfigRoots(config string, roots []string) (string, error) {
+	hoconConfig, err := hocon.ParseString(config)
+	if err != nil {
+		return "", emperror.Wrap(err, "failed to parse config")
+	}
+	root := hoconConfig.GetRoot().(hocon.Object)
+
+	rendered := ""
+	for _, key := range roots {
+		value, ok := root[key]
+		if !ok {
+			return "", emperror.Errorf("the config %s is removed", key)
+		}
+		str, err := renderConfigValue(value)
+		if err != nil {
+			return "", emperror.Wrapf(err, "failed to render config %s", key)
+		}
+		rendered += fmt.Sprintf("%s = %s\n", strconv.Quote(key), str)
+	}
+
+	renderedConfig, err := hocon.ParseString(rendered)
+	if err != nil {
+		return "", emperror.Wrap(err, "failed to parse the rendered config")
+	}
+	for _, key := range roots {
+		if configValueString(root[key]) != configValueString(renderedConfig.GetRoot().(hocon.Object)[key]) {
+			return "", emperror.Errorf("the rendered config %s is not the same as the config", key)
+		}
+	}
+	return rendered, nil
+}
+
+// renderConfigValue renders the HOCON value, the strings are always quoted.
+func renderConfigValue(value hocon.Value) (string, error) {
+	switch v := value.(type) {
+	case hocon.Object:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			str, err := renderConfigValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, fmt.Sprintf("%s = %s", strconv.Quote(key), str))
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	case hocon.Array:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, err := renderConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case hocon.String:
+		str := string(v)
+		if len(str) >= 2 && strings.HasPrefix(str, `"`) && strings.HasSuffix(str, `"`) {
+			return str, nil
+		}
+		return strconv.Quote(str), nil
+	case hocon.Int, hocon.Boolean, hocon.Null:
+		return v.String(), nil
+	case hocon.Float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case hocon.Float64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case hocon.Duration:
+		d := time.Duration(v)
+		if d%time.Second == 0 {
+			return fmt.Sprintf(`"%ds"`, d/time.Second), nil
+		}
+		return fmt.Sprintf(`"%dms"`, d/time.Millisecond), nil
+	case nil:
+		return "", emperror.New("the value is unresolved")
+	}
+	if value.Type() == hocon.ConcatenationType {
+		// The unquoted string with the digits, like 1MB, is parsed as the concatenation
+		return strconv.Quote(strings.ReplaceAll(value.String(), `"`, "")), nil
+	}
+	return "", emperror.Errorf("unsupported value %s", value.String())
+}
@@ -0,0 +1,140 @@
+package v2beta1
+
+import (
+	"testing"
+
+	"github.com/rory-z/go-hocon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	desired, err := hocon.ParseString(`
+		listeners.tcp.default.bind = 1883
+		mqtt.max_packet_size = 1MB
+		authentication = [{mechanism = password_based, backend = built_in_database}]
+		node.cookie = emqxsecretcookie
+		log.console.level = warning
+		retainer.msg_expiry_interval = 1h
+	`)
+	assert.Nil(t, err)
+
+	live, err := hocon.ParseString(`
+		listeners.tcp.default {
+			bind = "0.0.0.0:1883"
+			max_connections = infinity
+		}
+		mqtt.max_packet_size = 1MB
+		authentication = [{backend = built_in_database, mechanism = password_based}]
+		node.cookie = "******"
+		log.console.level = debug
+		retainer.msg_expiry_interval = 60m
+	`)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"log.console.level"}, diffConfig(desired.GetRoot().(hocon.Object), live.GetRoot().(hocon.Object)))
+
+	empty, _ := hocon.ParseString("")
+	assert.Equal(t, []string{
+		"authentication",
+		"listeners.tcp.default.bind",
+		"log.console.level",
+		"mqtt.max_packet_size",
+		"node.cookie",
+		"retainer.msg_expiry_interval",
+	}, diffConfig(desired.GetRoot().(hocon.Object), empty.GetRoot().(hocon.Object)))
+	assert.Empty(t, diffConfig(empty.GetRoot().(hocon.Object), live.GetRoot().(hocon.Object)))
+
+	t.Run("arrays with the default values", func(t *testing.T) {
+		desired, err := hocon.ParseString(`
+			authentication = [{mechanism = password_based, backend = built_in_database}]
+			authorization.sources = [{type = file, enable = true}, {type = built_in_database}]
+		`)
+		assert.Nil(t, err)
+
+		live, err := hocon.ParseString(`
+			authentication = [{mechanism = password_based, backend = built_in_database, enable = true, password_hash_algorithm {name = sha256, salt_position = prefix}}]
+			authorization.sources = [{type = file, enable = true, path = "/opt/emqx/etc/acl.conf"}, {type = built_in_database, enable = true, max_rules = 100}]
+		`)
+		assert.Nil(t, err)
+		assert.Empty(t, diffConfig(desired.GetRoot().(hocon.Object), live.GetRoot().(hocon.Object)))
+
+		live, err = hocon.ParseString(`
+			authentication = [{mechanism = password_based, backend = mysql, enable = true}]
+			authorization.sources = [{type = file, enable = true}]
+		`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"authentication.0.backend",
+			"authorization.sources",
+		}, diffConfig(desired.GetRoot().(hocon.Object), live.GetRoot().(hocon.Object)))
+	})
+}
+
+func TestDiffConfigChanges(t *testing.T) {
+	oldConf := `
+		mqtt.max_packet_size = 1MB
+		log.console.level = warning
+		authorization.no_match = allow
+	`
+	// Reordered keys and whitespace changes are not changes
+	paths, err := diffConfigChanges(oldConf, `
+		log { console { level = warning } }
+		authorization.no_match = "allow"
+		mqtt.max_packet_size =   1MB
+	`)
+	assert.Nil(t, err)
+	assert.Empty(t, paths)
+
+	paths, err = diffConfigChanges(oldConf, `
+		mqtt.max_packet_size = 2MB
+		log.console.level = warning
+		retainer.enable = true
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"authorization.no_match", "mqtt.max_packet_size", "retainer.enable"}, paths)
+	assert.Equal(t, []string{"authorization", "mqtt", "retainer"}, getConfigRoots(paths))
+
+	_, err = diffConfigChanges(oldConf, "mqtt {")
+	assert.Error(t, err)
+}
+
+func TestRenderConfigRoots(t *testing.T) {
+	config := `
+		mqtt.max_packet_size = 1MB
+		mqtt.idle_timeout = 15s
+		log.console.level = warning
+		authentication = [{mechanism = password_based, backend = built_in_database, password_hash_algorithm {name = sha256}}]
+		listeners.tcp.default.bind = "0.0.0.0:1883"
+		sysmon.os.cpu_high_watermark = 0.8
+		retainer.enable = true
+	`
+	got, err := renderConfigRoots(config, []string{"authentication", "listeners", "mqtt", "retainer", "sysmon"})
+	assert.Nil(t, err)
+
+	rendered, err := hocon.ParseString(got)
+	assert.Nil(t, err)
+	assert.NotContains(t, rendered.GetRoot().(hocon.Object), "log")
+	desired, _ := hocon.ParseString(config)
+	desiredObj := desired.GetRoot().(hocon.Object)
+	delete(desiredObj, "log")
+	assert.Empty(t, diffConfig(desiredObj, rendered.GetRoot().(hocon.Object)))
+	assert.Equal(t, "1MB", configValueString(rendered.GetRoot().(hocon.Object)["mqtt"].(hocon.Object)["max_packet_size"]))
+	assert.Contains(t, got, `"cpu_high_watermark" = 0.8`)
+
+	got, err = renderConfigRoots(config, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	t.Run("removed root", func(t *testing.T) {
+		// The root removed from the config can not be rendered, so the caller falls back to the whole config
+		newConfig := "mqtt.max_packet_size = 1MB"
+		paths, err := diffConfigChanges(config, newConfig)
+		assert.Nil(t, err)
+		roots := getConfigRoots(paths)
+		assert.Equal(t, []string{"authentication", "listeners", "log", "mqtt", "retainer", "sysmon"}, roots)
+		assert.Equal(t, []string{"authentication", "listeners", "log", "retainer", "sysmon"}, getRemovedConfigRoots(newConfig, roots))
+
+		_, err = renderConfigRoots(newConfig, roots)
+		assert.ErrorContains(t, err, "the config authentication is removed")
+	})
+}
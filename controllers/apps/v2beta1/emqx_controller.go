@@ -18,10 +18,15 @@ package v2beta1
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"net"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	emperror "emperror.dev/errors"
@@ -29,6 +34,7 @@ import (
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
 	"github.com/rory-z/go-hocon"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -36,11 +42,14 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	k8sHandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	"github.com/emqx/emqx-operator/internal/handler"
@@ -95,6 +104,7 @@ func (r *EMQXReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	instance := &appsv2beta1.EMQX{}
 	if err := r.Client.Get(ctx, req.NamespacedName, instance); err != nil {
 		if k8sErrors.IsNotFound(err) {
+			releaseManagementAPITLSConfig(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
@@ -124,13 +134,20 @@ func (r *EMQXReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		&updatePodConditions{r},
 		&updateStatus{r},
 		&addHeadlessSvc{r},
+		&syncTLS{r},
+		&addConfigMaps{r},
+		&syncNodeCookie{r},
 		&addCore{r},
 		&addRepl{r},
 		&addPdb{r},
 		&syncConfig{r},
 		&addSvc{r},
+		&addNetworkPolicy{r},
+		&syncBootstrapAPIKey{r},
 		&updatePodConditions{r},
 		&updateStatus{r},
+		&syncRollback{r},
+		&syncHooks{r},
 		&syncPods{r},
 		&syncSets{r},
 	} {
@@ -156,36 +173,104 @@ func (r *EMQXReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *EMQXReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appsv2beta1.EMQX{}, configSourceIndexKey, indexEMQXConfigSources); err != nil {
+		return emperror.Wrap(err, "failed to index the config sources of EMQX")
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv2beta1.EMQX{}).
-		WithEventFilter(predicate.Funcs{
+		For(&appsv2beta1.EMQX{}, builder.WithPredicates(predicate.Funcs{
 			UpdateFunc: func(e event.UpdateEvent) bool {
 				// Ignore updates to CR status in which case metadata.Generation does not change
 				return e.ObjectNew.GetGeneration() != e.ObjectOld.GetGeneration()
 			},
-		}).
+		})).
+		// Watch the hook Jobs, so the update continues when the preSwitch hook Job finishes
+		Owns(&batchv1.Job{}).
+		// Watch the config sources, the secrets of the placeholders and the TLS certificates,
+		// so the EMQX config is synced and the listeners are reloaded when they are changed
+		Watches(&corev1.ConfigMap{},
+			k8sHandler.EnqueueRequestsFromMapFunc(r.findEMQXForConfigSource),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
+		Watches(&corev1.Secret{},
+			k8sHandler.EnqueueRequestsFromMapFunc(r.findEMQXForConfigSource),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}, predicate.NewPredicateFuncs(isConfigSourceSecret)),
+		).
 		Complete(r)
 }
 
+// configSourceIndexKey indexes the EMQX custom resources by the referenced ConfigMaps and Secrets, like "configmap/<name>" and "secret/<name>".
+const configSourceIndexKey = "emqx.configSources"
+
+// indexEMQXConfigSources returns the ConfigMaps and Secrets referenced by the config sources, secret placeholders and TLS certificates of EMQX.
+func indexEMQXConfigSources(obj client.Object) []string {
+	instance, ok := obj.(*appsv2beta1.EMQX)
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	for _, source := range instance.Spec.Config.Sources {
+		if source.ConfigMapKeyRef != nil {
+			keys = append(keys, configSourceIndexValue(&corev1.ConfigMap{}, source.ConfigMapKeyRef.Name))
+		}
+		if source.SecretKeyRef != nil {
+			keys = append(keys, configSourceIndexValue(&corev1.Secret{}, source.SecretKeyRef.Name))
+		}
+	}
+	for _, name := range append(getSecretPlaceholderNames(instance.Spec.Config.Data), getTLSSecretNames(instance)...) {
+		keys = append(keys, configSourceIndexValue(&corev1.Secret{}, name))
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+func configSourceIndexValue(obj client.Object, name string) string {
+	if _, isSecret := obj.(*corev1.Secret); isSecret {
+		return "secret/" + name
+	}
+	return "configmap/" + name
+}
+
+// isConfigSourceSecret filters out the Secrets that can never be referenced by EMQX, like the service account tokens and the Helm releases.
+func isConfigSourceSecret(obj client.Object) bool {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return true
+	}
+	return secret.Type != corev1.SecretTypeServiceAccountToken && secret.Type != "helm.sh/release.v1"
+}
+
+// findEMQXForConfigSource returns the EMQX custom resources whose config sources, secret placeholders or TLS certificates reference the ConfigMap or Secret.
+func (r *EMQXReconciler) findEMQXForConfigSource(ctx context.Context, obj client.Object) []reconcile.Request {
+	list := &appsv2beta1.EMQXList{}
+	if err := r.Client.List(ctx, list,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{configSourceIndexKey: configSourceIndexValue(obj, obj.GetName())},
+	); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, instance := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&instance)})
+	}
+	return requests
+}
+
 func newRequester(ctx context.Context, k8sClient client.Client, instance *appsv2beta1.EMQX) (innerReq.RequesterInterface, error) {
 	username, password, err := getBootstrapAPIKey(ctx, k8sClient, instance)
 	if err != nil {
 		return nil, err
 	}
 
-	portMap, err := appsv2beta1.GetDashboardPortMap(instance.Spec.Config.Data)
+	schema, port, err := getManagementAPISchemaAndPort(instance)
 	if err != nil {
 		return nil, err
 	}
-
-	var schema, port string
-	if dashboardHttps, ok := portMap["dashboard-https"]; ok {
-		schema = "https"
-		port = strconv.FormatInt(int64(dashboardHttps), 10)
-	}
-	if dashboard, ok := portMap["dashboard"]; ok {
-		schema = "http"
-		port = strconv.FormatInt(int64(dashboard), 10)
+	var tlsConfig *tls.Config
+	if schema == "https" {
+		if tlsConfig, err = getManagementAPITLSConfig(ctx, k8sClient, instance); err != nil {
+			return nil, err
+		}
 	}
 
 	podList := &corev1.PodList{}
@@ -204,10 +289,11 @@ func newRequester(ctx context.Context, k8sClient client.Client, instance *appsv2
 			for _, cond := range pod.Status.Conditions {
 				if cond.Type == corev1.ContainersReady && cond.Status == corev1.ConditionTrue {
 					return &innerReq.Requester{
-						Schema:   schema,
-						Host:     net.JoinHostPort(pod.Status.PodIP, port),
-						Username: username,
-						Password: password,
+						Schema:    schema,
+						Host:      net.JoinHostPort(pod.Status.PodIP, port),
+						Username:  username,
+						Password:  password,
+						TLSConfig: tlsConfig,
 					}, nil
 				}
 			}
@@ -217,6 +303,109 @@ func newRequester(ctx context.Context, k8sClient client.Client, instance *appsv2
 	return nil, nil
 }
 
+// getManagementAPISchemaAndPort returns the schema and the port of the dashboard listener to request the EMQX management API.
+// The dashboard http listener is preferred, unless the TLS config of the management API is set.
+func getManagementAPISchemaAndPort(instance *appsv2beta1.EMQX) (schema, port string, err error) {
+	portMap, err := appsv2beta1.GetDashboardPortMap(instance.Spec.Config.Data)
+	if err != nil {
+		return "", "", err
+	}
+
+	if dashboardHttps, ok := portMap["dashboard-https"]; ok {
+		schema = "https"
+		port = strconv.FormatInt(int64(dashboardHttps), 10)
+		if instance.Spec.ManagementAPI != nil && instance.Spec.ManagementAPI.TLS != nil {
+			return schema, port, nil
+		}
+	}
+	if dashboard, ok := portMap["dashboard"]; ok {
+		schema = "http"
+		port = strconv.FormatInt(int64(dashboard), 10)
+	}
+	return schema, port, nil
+}
+
+type cachedTLSConfig struct {
+	hash   string
+	config *tls.Config
+}
+
+// managementAPITLSConfigs caches the TLS configs of the EMQX management API by the EMQX instances,
+// the same TLS config is returned until its secrets change, so the HTTP client of the TLS config is reused by the requester.
+var managementAPITLSConfigs = struct {
+	sync.Mutex
+	configs map[types.NamespacedName]cachedTLSConfig
+}{configs: map[types.NamespacedName]cachedTLSConfig{}}
+
+// getManagementAPITLSConfig returns the TLS config to request the EMQX management API, or nil if it's not set.
+func getManagementAPITLSConfig(ctx context.Context, k8sClient client.Client, instance *appsv2beta1.EMQX) (*tls.Config, error) {
+	if instance.Spec.ManagementAPI == nil || instance.Spec.ManagementAPI.TLS == nil {
+		releaseManagementAPITLSConfig(client.ObjectKeyFromObject(instance))
+		return nil, nil
+	}
+	spec := instance.Spec.ManagementAPI.TLS
+
+	var caData, certData, keyData []byte
+	if spec.CASecretRef != nil {
+		secret := &corev1.Secret{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: spec.CASecretRef.Name}, secret); err != nil {
+			return nil, emperror.Wrapf(err, "failed to get secret %s", spec.CASecretRef.Name)
+		}
+		caData = secret.Data[corev1.ServiceAccountRootCAKey]
+	}
+	if spec.ClientCertSecretRef != nil {
+		secret := &corev1.Secret{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: spec.ClientCertSecretRef.Name}, secret); err != nil {
+			return nil, emperror.Wrapf(err, "failed to get secret %s", spec.ClientCertSecretRef.Name)
+		}
+		certData, keyData = secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	}
+
+	hashData, _ := json.Marshal([]interface{}{spec, caData, certData, keyData})
+	hash := computeStringHash(string(hashData))
+	managementAPITLSConfigs.Lock()
+	defer managementAPITLSConfigs.Unlock()
+	key := client.ObjectKeyFromObject(instance)
+	if cached, ok := managementAPITLSConfigs.configs[key]; ok && cached.hash == hash {
+		return cached.config, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: spec.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if spec.CASecretRef != nil {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caData) {
+			return nil, emperror.Errorf("the secret %s does not contain a valid %s", spec.CASecretRef.Name, corev1.ServiceAccountRootCAKey)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if spec.ClientCertSecretRef != nil {
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, emperror.Wrapf(err, "the secret %s does not contain a valid client certificate", spec.ClientCertSecretRef.Name)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cached, ok := managementAPITLSConfigs.configs[key]; ok {
+		innerReq.ReleaseTLSConfig(cached.config)
+	}
+	managementAPITLSConfigs.configs[key] = cachedTLSConfig{hash: hash, config: tlsConfig}
+	return tlsConfig, nil
+}
+
+// releaseManagementAPITLSConfig removes the cached TLS config of the EMQX instance, and releases its HTTP client.
+func releaseManagementAPITLSConfig(key types.NamespacedName) {
+	managementAPITLSConfigs.Lock()
+	defer managementAPITLSConfigs.Unlock()
+	if cached, ok := managementAPITLSConfigs.configs[key]; ok {
+		innerReq.ReleaseTLSConfig(cached.config)
+		delete(managementAPITLSConfigs.configs, key)
+	}
+}
+
 func getBootstrapAPIKey(ctx context.Context, client client.Client, instance *appsv2beta1.EMQX) (username, password string, err error) {
 	bootstrapAPIKey := &corev1.Secret{}
 	if err = client.Get(ctx, types.NamespacedName{
@@ -227,11 +416,17 @@ func getBootstrapAPIKey(ctx context.Context, client client.Client, instance *app
 		return
 	}
 
+	// The API key of the operator is changed once it's rotated
+	key := appsv2beta1.DefaultBootstrapAPIKey
+	if k, ok := bootstrapAPIKey.Annotations[appsv2beta1.AnnotationsBootstrapAPIKeyKey]; ok {
+		key = k
+	}
+
 	if data, ok := bootstrapAPIKey.Data["bootstrap_api_key"]; ok {
 		users := strings.Split(string(data), "\n")
 		for _, user := range users {
 			index := strings.Index(user, ":")
-			if index > 0 && user[:index] == appsv2beta1.DefaultBootstrapAPIKey {
+			if index > 0 && user[:index] == key {
 				username = user[:index]
 				password = user[index+1:]
 				return
@@ -0,0 +1,175 @@
+package v2beta1
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetManagementAPISchemaAndPort(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	schema, port, err := getManagementAPISchemaAndPort(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "http", schema)
+	assert.Equal(t, "18083", port)
+
+	instance.Spec.Config.Data = "dashboard.listeners.https.bind = 18084"
+	schema, port, err = getManagementAPISchemaAndPort(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "http", schema)
+	assert.Equal(t, "18083", port)
+
+	instance.Spec.ManagementAPI = &appsv2beta1.ManagementAPI{TLS: &appsv2beta1.ManagementAPITLS{}}
+	schema, port, err = getManagementAPISchemaAndPort(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "https", schema)
+	assert.Equal(t, "18084", port)
+
+	instance.Spec.Config.Data = "dashboard.listeners.https.bind = 0"
+	schema, port, err = getManagementAPISchemaAndPort(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "http", schema)
+	assert.Equal(t, "18083", port)
+}
+
+func TestGetManagementAPITLSConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "emqx"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "emqx"},
+			Data:       map[string][]byte{"ca.crt": certPEM},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "emqx"},
+			Data:       map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM},
+		},
+	).Build()
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	tlsConfig, err := getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	instance.Spec.ManagementAPI = &appsv2beta1.ManagementAPI{
+		TLS: &appsv2beta1.ManagementAPITLS{
+			CASecretRef:         &corev1.LocalObjectReference{Name: "ca"},
+			ClientCertSecretRef: &corev1.LocalObjectReference{Name: "client"},
+			ServerName:          "emqx-dashboard.emqx.svc",
+		},
+	}
+	tlsConfig, err = getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "emqx-dashboard.emqx.svc", tlsConfig.ServerName)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+
+	// The same TLS config is returned until the secrets change, so its HTTP client is reused
+	got, err := getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.Nil(t, err)
+	assert.Same(t, tlsConfig, got)
+	instance.Spec.ManagementAPI.TLS.ServerName = "emqx-dashboard"
+	got, err = getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.Nil(t, err)
+	assert.NotSame(t, tlsConfig, got)
+	assert.Equal(t, "emqx-dashboard", got.ServerName)
+
+	instance.Spec.ManagementAPI.TLS.CASecretRef.Name = "client"
+	_, err = getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.ErrorContains(t, err, "does not contain a valid ca.crt")
+
+	instance.Spec.ManagementAPI.TLS.CASecretRef.Name = "fake"
+	_, err = getManagementAPITLSConfig(context.Background(), k8sClient, instance)
+	assert.Error(t, err)
+}
+
+func TestFindEMQXForConfigSource(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &EMQXReconciler{Handler: &handler.Handler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).
+			WithIndex(&appsv2beta1.EMQX{}, configSourceIndexKey, indexEMQXConfigSources).
+			WithObjects(
+				&appsv2beta1.EMQX{
+					ObjectMeta: metav1.ObjectMeta{Name: "sources", Namespace: "emqx"},
+					Spec: appsv2beta1.EMQXSpec{
+						Config: appsv2beta1.Config{
+							Sources: []appsv2beta1.ConfigSource{
+								{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}, Key: "emqx.conf"}},
+							},
+							Data: `authentication.password_hash_algorithm.salt = "${secret:salt/value}"`,
+						},
+					},
+				},
+				&appsv2beta1.EMQX{
+					ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "emqx"},
+					Spec: appsv2beta1.EMQXSpec{
+						TLS: []appsv2beta1.TLSCertificate{{Name: "mqtt"}},
+					},
+				},
+			).Build(),
+	}}
+
+	find := func(obj client.Object) []string {
+		names := []string{}
+		for _, req := range r.findEMQXForConfigSource(context.Background(), obj) {
+			names = append(names, req.Name)
+		}
+		return names
+	}
+	objectMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "emqx"}
+	}
+
+	assert.Equal(t, []string{"sources"}, find(&corev1.ConfigMap{ObjectMeta: objectMeta("config")}))
+	assert.Empty(t, find(&corev1.Secret{ObjectMeta: objectMeta("config")}))
+	assert.Equal(t, []string{"sources"}, find(&corev1.Secret{ObjectMeta: objectMeta("salt")}))
+	assert.Equal(t, []string{"tls"}, find(&corev1.Secret{ObjectMeta: objectMeta("tls-mqtt-tls")}))
+	assert.Empty(t, find(&corev1.ConfigMap{ObjectMeta: objectMeta("other")}))
+	assert.Empty(t, find(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"}}))
+
+	assert.True(t, isConfigSourceSecret(&corev1.Secret{Type: corev1.SecretTypeOpaque}))
+	assert.False(t, isConfigSourceSecret(&corev1.Secret{Type: corev1.SecretTypeServiceAccountToken}))
+	assert.False(t, isConfigSourceSecret(&corev1.Secret{Type: "helm.sh/release.v1"}))
+}
@@ -0,0 +1,175 @@
+package v2beta1
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const listenersTLSMountPath = "/mounted/listeners"
+
+// defaultListeners are the listeners enabled by EMQX by default
+var defaultListeners = []string{"tcp-default", "ssl-default", "ws-default", "wss-default"}
+
+// mergeListenersConfig merges the listeners of .spec.listeners into the config,
+// or merges the default listeners if .spec.listeners is not set.
+func mergeListenersConfig(instance *appsv2beta1.EMQX, config string) string {
+	if len(instance.Spec.Listeners) == 0 {
+		return mergeDefaultConfig(config)
+	}
+	return fmt.Sprintf("%s\n%s", config, generateListenersConfig(instance.Spec.Listeners))
+}
+
+// generateListenersConfig renders the listeners of .spec.listeners into HOCON,
+// the default listeners of EMQX that are not in .spec.listeners are disabled.
+func generateListenersConfig(listeners []appsv2beta1.Listener) string {
+	config := ""
+	names := []string{}
+	for _, listener := range listeners {
+		name := appsv2beta1.GetListenerServicePort(listener).Name
+		names = append(names, name)
+
+		prefix := fmt.Sprintf("listeners.%s.%s", listener.Type, listener.Name)
+		config += fmt.Sprintf("%s.enable = %t\n", prefix, appsv2beta1.IsListenerEnabled(listener))
+		config += fmt.Sprintf("%s.bind = %d\n", prefix, listener.Port)
+		if listener.TLSSecretRef != nil {
+			config += fmt.Sprintf("%s.ssl_options.certfile = \"%s/%s/%s\"\n", prefix, listenersTLSMountPath, name, corev1.TLSCertKey)
+			config += fmt.Sprintf("%s.ssl_options.keyfile = \"%s/%s/%s\"\n", prefix, listenersTLSMountPath, name, corev1.TLSPrivateKeyKey)
+		}
+	}
+	for _, name := range defaultListeners {
+		if !slices.Contains(names, name) {
+			config += fmt.Sprintf("listeners.%s.enable = false\n", strings.Replace(name, "-", ".", 1))
+		}
+	}
+	return config
+}
+
+// getListenersContainerPorts returns the container ports of the enabled listeners in .spec.listeners
+func getListenersContainerPorts(instance *appsv2beta1.EMQX) []corev1.ContainerPort {
+	svcPorts := []corev1.ServicePort{}
+	for _, listener := range instance.Spec.Listeners {
+		if appsv2beta1.IsListenerEnabled(listener) {
+			svcPorts = append(svcPorts, appsv2beta1.GetListenerServicePort(listener))
+		}
+	}
+	return appsv2beta1.TransServicePortsToContainerPorts(svcPorts)
+}
+
+// addListenersTLSVolumes mounts the TLS secrets of the listeners in .spec.listeners to the EMQX container
+func addListenersTLSVolumes(instance *appsv2beta1.EMQX, podSpec *corev1.PodSpec) {
+	for _, listener := range instance.Spec.Listeners {
+		if listener.TLSSecretRef == nil {
+			continue
+		}
+		name := appsv2beta1.GetListenerServicePort(listener).Name
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: name + "-tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: listener.TLSSecretRef.Name,
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      name + "-tls",
+			MountPath: fmt.Sprintf("%s/%s", listenersTLSMountPath, name),
+			ReadOnly:  true,
+		})
+	}
+}
+
+// isListenerExposed returns true if the listener in .spec.listeners is exposed by the service with the name,
+// the empty name means the listeners service.
+func isListenerExposed(listener appsv2beta1.Listener, serviceName string) bool {
+	if !appsv2beta1.IsListenerEnabled(listener) {
+		return false
+	}
+	if listener.Service == nil {
+		return serviceName == ""
+	}
+	if listener.Service.Enabled != nil && !*listener.Service.Enabled {
+		return false
+	}
+	return listener.Service.Name == serviceName
+}
+
+// getListenersServicePortsBySpec returns the service ports and the annotations of the service with the name
+func getListenersServicePortsBySpec(instance *appsv2beta1.EMQX, serviceName string) ([]corev1.ServicePort, map[string]string) {
+	ports := []corev1.ServicePort{}
+	annotations := map[string]string{}
+	for _, listener := range instance.Spec.Listeners {
+		if !isListenerExposed(listener, serviceName) {
+			continue
+		}
+		ports = append(ports, appsv2beta1.GetListenerServicePort(listener))
+		if listener.Service != nil {
+			for key, value := range listener.Service.Annotations {
+				annotations[key] = value
+			}
+		}
+	}
+	return ports, annotations
+}
+
+// generateListenerDedicatedServices generates the services named in .spec.listeners[].service.name,
+// the names are prefixed with the EMQX name, and the services are labeled to prune the stale ones.
+func generateListenerDedicatedServices(instance *appsv2beta1.EMQX) []*corev1.Service {
+	serviceTypes := map[string]corev1.ServiceType{}
+	for _, listener := range instance.Spec.Listeners {
+		if listener.Service == nil || listener.Service.Name == "" || !isListenerExposed(listener, listener.Service.Name) {
+			continue
+		}
+		if _, ok := serviceTypes[listener.Service.Name]; !ok || listener.Service.Type != "" {
+			serviceTypes[listener.Service.Name] = listener.Service.Type
+		}
+	}
+
+	names := []string{}
+	for name := range serviceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := []*corev1.Service{}
+	for _, name := range names {
+		ports, annotations := getListenersServicePortsBySpec(instance, name)
+		svcType := serviceTypes[name]
+		if svcType == "" {
+			svcType = corev1.ServiceTypeClusterIP
+		}
+		if svcType == corev1.ServiceTypeClusterIP {
+			for i := range ports {
+				ports[i].NodePort = 0
+			}
+		}
+
+		selector := appsv2beta1.DefaultCoreLabels(instance)
+		if appsv2beta1.IsExistReplicant(instance) && instance.Status.ReplicantNodesStatus.ReadyReplicas > 0 {
+			selector = appsv2beta1.DefaultReplicantLabels(instance)
+		}
+		services = append(services, &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Service",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   instance.Namespace,
+				Name:        instance.ListenerServiceNamespacedName(name).Name,
+				Labels:      appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultLabels(instance), appsv2beta1.LabelsListenerServiceKey, name),
+				Annotations: annotations,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:     svcType,
+				Ports:    ports,
+				Selector: selector,
+			},
+		})
+	}
+	return services
+}
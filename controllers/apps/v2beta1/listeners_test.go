@@ -0,0 +1,112 @@
+package v2beta1
+
+import (
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/rory-z/go-hocon"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func TestMergeListenersConfig(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	assert.Equal(t, mergeDefaultConfig("a = 1"), mergeListenersConfig(instance, "a = 1"))
+
+	instance.Spec.Listeners = []appsv2beta1.Listener{
+		{Type: "tcp", Name: "default", Port: 11883},
+		{Type: "ssl", Name: "default", Port: 18883, TLSSecretRef: &corev1.LocalObjectReference{Name: "tls"}},
+		{Type: "ws", Name: "internal", Port: 18083, Enabled: ptr.To(false)},
+	}
+	got := mergeListenersConfig(instance, "listeners.tcp.default.bind = 1883\nlisteners.ssl.default.ssl_options.verify = verify_peer")
+	hoconConfig, err := hocon.ParseString(got)
+	assert.Nil(t, err)
+	assert.Equal(t, "11883", hoconConfig.GetString("listeners.tcp.default.bind"))
+	assert.Equal(t, "18883", hoconConfig.GetString("listeners.ssl.default.bind"))
+	assert.Equal(t, `"/mounted/listeners/ssl-default/tls.crt"`, hoconConfig.GetString("listeners.ssl.default.ssl_options.certfile"))
+	assert.Equal(t, `"/mounted/listeners/ssl-default/tls.key"`, hoconConfig.GetString("listeners.ssl.default.ssl_options.keyfile"))
+	assert.Equal(t, `"verify_peer"`, hoconConfig.GetString("listeners.ssl.default.ssl_options.verify"))
+	assert.Equal(t, "false", hoconConfig.GetString("listeners.ws.internal.enable"))
+	// The default listeners that are not in .spec.listeners are disabled
+	assert.Equal(t, "false", hoconConfig.GetString("listeners.ws.default.enable"))
+	assert.Equal(t, "false", hoconConfig.GetString("listeners.wss.default.enable"))
+
+	ports, err := appsv2beta1.GetListenersServicePorts(got)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []corev1.ServicePort{
+		{Name: "tcp-default", Protocol: corev1.ProtocolTCP, Port: 11883, TargetPort: intstr.Parse("11883")},
+		{Name: "ssl-default", Protocol: corev1.ProtocolTCP, Port: 18883, TargetPort: intstr.Parse("18883")},
+	}, ports)
+}
+
+func TestListenersPodSpec(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.CoreTemplate.Spec.Replicas = ptr.To(int32(1))
+	instance.Spec.Listeners = []appsv2beta1.Listener{
+		{Type: "tcp", Name: "default", Port: 11883},
+		{Type: "quic", Name: "default", Port: 14567, TLSSecretRef: &corev1.LocalObjectReference{Name: "tls"}},
+		{Type: "ws", Name: "default", Port: 18083, Enabled: ptr.To(false)},
+	}
+
+	sts := getNewStatefulSet(instance)
+	assert.Subset(t, sts.Spec.Template.Spec.Containers[0].Ports, []corev1.ContainerPort{
+		{Name: "tcp-default", ContainerPort: 11883, Protocol: corev1.ProtocolTCP},
+		{Name: "quic-default", ContainerPort: 14567, Protocol: corev1.ProtocolUDP},
+	})
+	assert.NotContains(t, sts.Spec.Template.Spec.Containers[0].Ports, corev1.ContainerPort{Name: "ws-default", ContainerPort: 18083, Protocol: corev1.ProtocolTCP})
+	assert.Contains(t, sts.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "quic-default-tls",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: "tls"},
+		},
+	})
+	assert.Contains(t, sts.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "quic-default-tls",
+		MountPath: "/mounted/listeners/quic-default",
+		ReadOnly:  true,
+	})
+}
+
+func TestGenerateListenerServicesBySpec(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.Listeners = []appsv2beta1.Listener{
+		{Type: "tcp", Name: "default", Port: 1883, Service: &appsv2beta1.ListenerService{NodePort: 31883}},
+		{Type: "ssl", Name: "default", Port: 8883, Service: &appsv2beta1.ListenerService{Enabled: ptr.To(false)}},
+		{Type: "ws", Name: "default", Port: 8083, Service: &appsv2beta1.ListenerService{
+			Name:        "ws",
+			Type:        corev1.ServiceTypeNodePort,
+			NodePort:    30083,
+			Annotations: map[string]string{"foo": "bar"},
+		}},
+	}
+
+	svc := generateListenerService(instance, "")
+	assert.Equal(t, []corev1.ServicePort{
+		{Name: "tcp-default", Protocol: corev1.ProtocolTCP, Port: 1883, TargetPort: intstr.FromInt(1883)},
+	}, svc.Spec.Ports)
+
+	instance.Spec.ListenersServiceTemplate = &appsv2beta1.ServiceTemplate{
+		Enabled: ptr.To(true),
+		Spec:    corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+	svc = generateListenerService(instance, "")
+	assert.Equal(t, int32(31883), svc.Spec.Ports[0].NodePort)
+
+	services := generateListenerDedicatedServices(instance)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "emqx-ws", services[0].Name)
+	assert.Equal(t, "ws", services[0].Labels[appsv2beta1.LabelsListenerServiceKey])
+	assert.Equal(t, map[string]string{"foo": "bar"}, services[0].Annotations)
+	assert.Equal(t, corev1.ServiceTypeNodePort, services[0].Spec.Type)
+	assert.Equal(t, []corev1.ServicePort{
+		{Name: "ws-default", Protocol: corev1.ProtocolTCP, Port: 8083, TargetPort: intstr.FromInt(8083), NodePort: 30083},
+	}, services[0].Spec.Ports)
+	assert.Equal(t, appsv2beta1.DefaultCoreLabels(instance), services[0].Spec.Selector)
+}
@@ -191,7 +191,12 @@ func (s *replicantNodesProgressingStatus) nextStatus(ctx context.Context) {
 	}
 
 	updateRs, _, _ := getReplicaSetList(ctx, s.emqxStatusMachine.client, emqx)
-	if updateRs != nil && updateRs.Status.ReadyReplicas != 0 && updateRs.Status.ReadyReplicas == *emqx.Spec.ReplicantTemplate.Spec.Replicas {
+	replicas := *emqx.Spec.ReplicantTemplate.Spec.Replicas
+	if isRollingUpdate(emqx) && updateRs != nil {
+		// The update replicaSet grows step by step, just wait for the current step
+		replicas = *updateRs.Spec.Replicas
+	}
+	if updateRs != nil && updateRs.Status.ReadyReplicas != 0 && updateRs.Status.ReadyReplicas == replicas {
 		emqx.Status.SetCondition(metav1.Condition{
 			Type:    appsv2beta1.ReplicantNodesReady,
 			Status:  metav1.ConditionTrue,
@@ -0,0 +1,201 @@
+package v2beta1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+type apiKey struct {
+	Name      string `json:"name"`
+	APIKey    string `json:"api_key"`
+	APISecret string `json:"api_secret,omitempty"`
+}
+
+type syncBootstrapAPIKey struct {
+	*EMQXReconciler
+}
+
+// reconcile rotates the API key of the operator without downtime: a new API key is created by the EMQX API,
+// the bootstrap API key secret is switched to it for the operator and the future EMQX nodes, then the old API keys are revoked.
+func (s *syncBootstrapAPIKey) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface) subResult {
+	if r == nil || !instance.Status.IsConditionTrue(appsv2beta1.CoreNodesReady) {
+		return subResult{}
+	}
+
+	secret := &corev1.Secret{}
+	if err := s.Client.Get(ctx, instance.BootstrapAPIKeyNamespacedName(), secret); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to get bootstrap api key secret")}
+	}
+	now := time.Now()
+	if !isBootstrapAPIKeyRotationDue(instance, secret, now) {
+		return subResult{}
+	}
+
+	newKey, err := createAPIKeyByAPI(r, fmt.Sprintf("%s-%d", appsv2beta1.DefaultBootstrapAPIKey, now.Unix()))
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to create api key")}
+	}
+	// Make sure the new API key works before switching to it
+	newRequester := withAPIKey(r, newKey.APIKey, newKey.APISecret)
+	if _, err := listAPIKeysByAPI(newRequester); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to request the EMQX API with the new api key")}
+	}
+
+	oldKey := appsv2beta1.DefaultBootstrapAPIKey
+	if k, ok := secret.Annotations[appsv2beta1.AnnotationsBootstrapAPIKeyKey]; ok {
+		oldKey = k
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[appsv2beta1.AnnotationsBootstrapAPIKeyKey] = newKey.APIKey
+	secret.Data["bootstrap_api_key"] = []byte(replaceBootstrapAPIKey(string(secret.Data["bootstrap_api_key"]), oldKey, newKey.APIKey, newKey.APISecret))
+	if err := s.Client.Update(ctx, secret); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update bootstrap api key secret")}
+	}
+
+	if err := revokeOperatorAPIKeysByAPI(newRequester, oldKey, newKey.APIKey); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to revoke the old api keys")}
+	}
+
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+	delete(instance.Annotations, appsv2beta1.AnnotationsRotateBootstrapAPIKeyKey)
+	instance.Annotations[appsv2beta1.AnnotationsBootstrapAPIKeyRotatedAtKey] = now.Format(time.RFC3339)
+	if err := s.Client.Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
+	}
+	s.EventRecorder.Event(instance, corev1.EventTypeNormal, "BootstrapAPIKeyRotated", fmt.Sprintf("Rotated the API key of the operator to %s", newKey.Name))
+
+	// The requester of the following sub reconcilers uses the revoked API key
+	return subResult{result: ctrl.Result{Requeue: true}}
+}
+
+// isBootstrapAPIKeyRotationDue returns true if the rotation is requested by the annotation,
+// or the interval has passed since the last rotation or the creation of the bootstrap API key secret.
+func isBootstrapAPIKeyRotationDue(instance *appsv2beta1.EMQX, secret *corev1.Secret, now time.Time) bool {
+	if _, ok := instance.Annotations[appsv2beta1.AnnotationsRotateBootstrapAPIKeyKey]; ok {
+		return true
+	}
+	rotation := instance.Spec.BootstrapAPIKeyRotation
+	if rotation == nil || rotation.Interval == nil || rotation.Interval.Duration <= 0 {
+		return false
+	}
+	rotatedAt := secret.CreationTimestamp.Time
+	if t, err := time.Parse(time.RFC3339, instance.Annotations[appsv2beta1.AnnotationsBootstrapAPIKeyRotatedAtKey]); err == nil {
+		rotatedAt = t
+	}
+	return now.Sub(rotatedAt) >= rotation.Interval.Duration
+}
+
+// replaceBootstrapAPIKey replaces the API key of the operator in the bootstrap API key file, the other API keys are kept.
+func replaceBootstrapAPIKey(data, oldKey, newKey, newSecret string) string {
+	lines := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" || strings.HasPrefix(line, oldKey+":") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, newKey+":"+newSecret)
+	return strings.Join(lines, "\n")
+}
+
+// withAPIKey returns a copy of the requester that uses the API key.
+func withAPIKey(r innerReq.RequesterInterface, key, secret string) innerReq.RequesterInterface {
+	req, ok := r.(*innerReq.Requester)
+	if !ok {
+		return r
+	}
+	newReq := *req
+	newReq.Username = key
+	newReq.Password = secret
+	return &newReq
+}
+
+func createAPIKeyByAPI(r innerReq.RequesterInterface, name string) (*apiKey, error) {
+	url := r.GetURL("api/v5/api_key")
+	body, err := json.Marshal(map[string]interface{}{
+		"name":   name,
+		"desc":   "Created by EMQX operator",
+		"enable": true,
+	})
+	if err != nil {
+		return nil, emperror.Wrap(err, "failed to marshal api key")
+	}
+
+	resp, body, err := r.Request("POST", url, body, nil)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "failed to post API %s", url.String())
+	}
+	if resp.StatusCode != 200 {
+		return nil, emperror.Errorf("failed to post API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+
+	key := &apiKey{}
+	if err := json.Unmarshal(body, key); err != nil {
+		return nil, emperror.Wrap(err, "failed to unmarshal api key")
+	}
+	if key.APIKey == "" || key.APISecret == "" {
+		return nil, emperror.Errorf("the response of API %s does not contain the api key, body: %s", url.String(), body)
+	}
+	return key, nil
+}
+
+func listAPIKeysByAPI(r innerReq.RequesterInterface) ([]apiKey, error) {
+	url := r.GetURL("api/v5/api_key")
+
+	resp, body, err := r.Request("GET", url, nil, nil)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "failed to get API %s", url.String())
+	}
+	if resp.StatusCode != 200 {
+		return nil, emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+
+	keys := []apiKey{}
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, emperror.Wrap(err, "failed to unmarshal api keys")
+	}
+	return keys, nil
+}
+
+// revokeOperatorAPIKeysByAPI deletes the API keys of the operator except the current one,
+// so the API keys left by the interrupted rotations are revoked too.
+// The old API key is also imported from the bootstrap API key file by the EMQX nodes created after the last rotation.
+func revokeOperatorAPIKeysByAPI(r innerReq.RequesterInterface, oldKey, currentKey string) error {
+	keys, err := listAPIKeysByAPI(r)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.APIKey == currentKey {
+			continue
+		}
+		if key.APIKey != oldKey && key.Name != appsv2beta1.DefaultBootstrapAPIKey && !strings.HasPrefix(key.Name, appsv2beta1.DefaultBootstrapAPIKey+"-") {
+			continue
+		}
+
+		url := r.GetURL(fmt.Sprintf("api/v5/api_key/%s", key.Name))
+		resp, body, err := r.Request("DELETE", url, nil, nil)
+		if err != nil {
+			return emperror.Wrapf(err, "failed to delete API %s", url.String())
+		}
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			return emperror.Errorf("failed to delete API %s, status : %s, body: %s", url.String(), resp.Status, body)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package v2beta1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestIsBootstrapAPIKeyRotationDue(t *testing.T) {
+	now := time.Now()
+	instance := &appsv2beta1.EMQX{}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour))},
+	}
+	assert.False(t, isBootstrapAPIKeyRotationDue(instance, secret, now))
+
+	instance.Spec.BootstrapAPIKeyRotation = &appsv2beta1.BootstrapAPIKeyRotation{
+		Interval: &metav1.Duration{Duration: 3 * time.Hour},
+	}
+	assert.False(t, isBootstrapAPIKeyRotationDue(instance, secret, now))
+
+	instance.Spec.BootstrapAPIKeyRotation.Interval.Duration = time.Hour
+	assert.True(t, isBootstrapAPIKeyRotationDue(instance, secret, now))
+
+	instance.Annotations = map[string]string{
+		appsv2beta1.AnnotationsBootstrapAPIKeyRotatedAtKey: now.Add(-30 * time.Minute).Format(time.RFC3339),
+	}
+	assert.False(t, isBootstrapAPIKeyRotationDue(instance, secret, now))
+
+	instance.Annotations[appsv2beta1.AnnotationsRotateBootstrapAPIKeyKey] = "true"
+	assert.True(t, isBootstrapAPIKeyRotationDue(instance, secret, now))
+}
+
+func TestReplaceBootstrapAPIKey(t *testing.T) {
+	got := replaceBootstrapAPIKey("foo:bar\nemqx-operator-controller:old", appsv2beta1.DefaultBootstrapAPIKey, "new-key", "new-secret")
+	assert.Equal(t, "foo:bar\nnew-key:new-secret", got)
+
+	got = replaceBootstrapAPIKey("old-key:old-secret\n", "old-key", "new-key", "new-secret")
+	assert.Equal(t, "new-key:new-secret", got)
+}
+
+func TestSyncBootstrapAPIKey(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "emqx",
+			Namespace:   "emqx",
+			Annotations: map[string]string{appsv2beta1.AnnotationsRotateBootstrapAPIKeyKey: "true"},
+		},
+	}
+	instance.Status.Conditions = []metav1.Condition{{Type: appsv2beta1.CoreNodesReady, Status: metav1.ConditionTrue}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx-bootstrap-api-key", Namespace: "emqx"},
+		Data: map[string][]byte{
+			"bootstrap_api_key": []byte("foo:bar\nemqx-operator-controller:old"),
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, secret).WithStatusSubresource(instance).Build()
+	s := &syncBootstrapAPIKey{&EMQXReconciler{
+		Handler:       &handler.Handler{Client: k8sClient},
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(10),
+	}}
+
+	deleted := []string{}
+	r := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			switch method {
+			case "POST":
+				assert.Contains(t, string(body), `"name":"emqx-operator-controller-`)
+				return &http.Response{StatusCode: 200}, []byte(`{"name":"emqx-operator-controller-1","api_key":"new-key","api_secret":"new-secret"}`), nil
+			case "GET":
+				return &http.Response{StatusCode: 200}, []byte(`[
+					{"name":"emqx-operator-controller","api_key":"emqx-operator-controller"},
+					{"name":"emqx-operator-controller-0","api_key":"stale-key"},
+					{"name":"emqx-operator-controller-1","api_key":"new-key"},
+					{"name":"foo","api_key":"foo"}
+				]`), nil
+			case "DELETE":
+				deleted = append(deleted, url.Path)
+				return &http.Response{StatusCode: 204}, nil, nil
+			}
+			return &http.Response{StatusCode: 405}, nil, nil
+		},
+	}
+
+	result := s.reconcile(context.Background(), log.FromContext(context.Background()), instance, r)
+	assert.Nil(t, result.err)
+	assert.True(t, result.result.Requeue)
+	assert.Equal(t, []string{
+		"api/v5/api_key/emqx-operator-controller",
+		"api/v5/api_key/emqx-operator-controller-0",
+	}, deleted)
+
+	username, password, err := getBootstrapAPIKey(context.Background(), k8sClient, instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "new-key", username)
+	assert.Equal(t, "new-secret", password)
+
+	got := &corev1.Secret{}
+	assert.Nil(t, k8sClient.Get(context.Background(), instance.BootstrapAPIKeyNamespacedName(), got))
+	assert.Equal(t, "foo:bar\nnew-key:new-secret", string(got.Data["bootstrap_api_key"]))
+
+	gotInstance := &appsv2beta1.EMQX{}
+	assert.Nil(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(instance), gotInstance))
+	assert.NotContains(t, gotInstance.Annotations, appsv2beta1.AnnotationsRotateBootstrapAPIKeyKey)
+	assert.Contains(t, gotInstance.Annotations, appsv2beta1.AnnotationsBootstrapAPIKeyRotatedAtKey)
+
+	// The rotation is not due anymore
+	result = s.reconcile(context.Background(), log.FromContext(context.Background()), gotInstance, r)
+	assert.Nil(t, result.err)
+	assert.False(t, result.result.Requeue)
+}
@@ -2,9 +2,13 @@ package v2beta1
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	emperror "emperror.dev/errors"
 	semver "github.com/Masterminds/semver/v3"
@@ -16,34 +20,41 @@ import (
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	ctrl "sigs.k8s.io/controller-runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// The config of the running EMQX cluster is fetched by a full config GET, so it's not compared on every reconcile
+const configDriftCheckInterval = 5 * time.Minute
+
+var secretPlaceholderRegexp = regexp.MustCompile(`\$\{secret:([a-z0-9][-a-z0-9.]*)/([-._a-zA-Z0-9]+)\}`)
+
 type syncConfig struct {
 	*EMQXReconciler
 }
 
 func (s *syncConfig) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface) subResult {
-	confStr := mergeDefaultConfig(instance.Spec.Config.Data)
+	config, confStr, sourcesHash, err := s.renderEMQXConfig(ctx, instance)
+	if err != nil {
+		return subResult{err: err}
+	}
 
-	// Make sure the config map exists
+	if err := s.checkEnvConfigConflicts(ctx, instance, config); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to check the conflicts of the EMQX config")}
+	}
+
+	// The config map is created by addConfigMaps before the EMQX nodes
 	configMap := &corev1.ConfigMap{}
-	if err := s.Client.Get(ctx, types.NamespacedName{
-		Name:      instance.ConfigsNamespacedName().Name,
-		Namespace: instance.Namespace,
-	}, configMap); err != nil {
+	if err := s.Client.Get(ctx, instance.ConfigsNamespacedName(), configMap); err != nil {
 		if k8sErrors.IsNotFound(err) {
-			configMap = generateConfigMap(instance, confStr)
-			if err := ctrl.SetControllerReference(instance, configMap, s.Scheme); err != nil {
-				return subResult{err: emperror.Wrap(err, "failed to set controller reference for configMap")}
-			}
-			if err := s.Client.Create(ctx, configMap); err != nil {
-				return subResult{err: emperror.Wrap(err, "failed to create configMap")}
-			}
 			return subResult{}
 		}
 		return subResult{err: emperror.Wrap(err, "failed to get configMap")}
 	}
+	appliedConfStr, ok := configMap.Data[appliedConfigKey]
+	if !ok {
+		appliedConfStr = configMap.Data["emqx.conf"]
+	}
 
 	lastConfigStr, ok := instance.Annotations[appsv2beta1.AnnotationsLastEMQXConfigKey]
 	if !ok {
@@ -51,61 +62,209 @@ func (s *syncConfig) reconcile(ctx context.Context, logger logr.Logger, instance
 			instance.Annotations = map[string]string{}
 		}
 		instance.Annotations[appsv2beta1.AnnotationsLastEMQXConfigKey] = instance.Spec.Config.Data
+		setLastConfigSourcesHash(instance, sourcesHash)
 		if err := s.Client.Update(ctx, instance); err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
 		}
+		// The EMQX config is applied by the configMap when the EMQX nodes start
+		if err := s.updateConfigStatus(ctx, instance, config, nil, nil); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
 		return subResult{}
 	}
 
-	if lastConfigStr != instance.Spec.Config.Data {
+	if lastConfigStr != instance.Spec.Config.Data || instance.Annotations[appsv2beta1.AnnotationsLastEMQXConfigSourcesHashKey] != sourcesHash {
 		_, coreReady := instance.Status.GetCondition(appsv2beta1.CoreNodesReady)
 		if coreReady == nil || !instance.Status.IsConditionTrue(appsv2beta1.CoreNodesReady) {
 			return subResult{}
 		}
 
-		v, _ := semver.NewVersion(instance.Status.CoreNodes[0].Version)
-		if v.LessThan(semver.MustParse("5.7.0")) {
-			// Delete readonly configs
-			hoconConfig, _ := hocon.ParseString(confStr)
-			hoconConfigObj := hoconConfig.GetRoot().(hocon.Object)
-			if _, ok := hoconConfigObj["node"]; ok {
-				s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", "Won't update `node` config, because it's readonly config")
-				delete(hoconConfigObj, "node")
+		// Only push the root keys that are semantically changed since the last applied config
+		var removed []string
+		pushConfStr := ""
+		mode := instance.Spec.Config.Mode
+		paths, err := diffConfigChanges(appliedConfStr, confStr)
+		if err == nil {
+			roots := getConfigRoots(paths)
+			if isReadOnlyDashboardConfig(instance) {
+				roots = slices.DeleteFunc(roots, func(root string) bool {
+					if slices.Contains([]string{"node", "cluster", "dashboard", "rpc"}, root) {
+						removed = append(removed, root)
+						return true
+					}
+					return false
+				})
 			}
-			if _, ok := hoconConfigObj["cluster"]; ok {
-				s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", "Won't update `cluster` config, because it's readonly config")
-				delete(hoconConfigObj, "cluster")
+			paths = slices.DeleteFunc(paths, func(path string) bool {
+				return !slices.Contains(roots, getConfigRoots([]string{path})[0])
+			})
+			if removedRoots := getRemovedConfigRoots(confStr, roots); len(removedRoots) > 0 {
+				// The merge of the changed root keys can not remove a root key from the running EMQX cluster,
+				// so the whole EMQX config is replaced, and the removed root keys are reset to the default values
+				err = emperror.Errorf("the config %s is removed", strings.Join(removedRoots, ", "))
+				mode = "Replace"
+			} else {
+				pushConfStr, err = renderConfigRoots(confStr, roots)
 			}
-			if _, ok := hoconConfigObj["dashboard"]; ok {
-				s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", "Won't update `dashboard` config, because it's readonly config")
-				delete(hoconConfigObj, "dashboard")
+		}
+		if err != nil {
+			logger.V(1).Info("failed to diff the EMQX config, push the whole EMQX config", "reason", err.Error())
+			paths, removed = nil, nil
+			pushConfStr = confStr
+			if isReadOnlyDashboardConfig(instance) {
+				// Delete readonly configs
+				pushConfStr, removed = removeConfigRoots(confStr, []string{"node", "cluster", "dashboard", "rpc"})
 			}
-			if _, ok := hoconConfigObj["rpc"]; ok {
-				s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", "Won't update `rpc` config, because it's readonly config")
-				delete(hoconConfigObj, "rpc")
+		}
+		for _, root := range removed {
+			if instance.Spec.Config.RollOnReadOnlyChange {
+				s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", fmt.Sprintf("Won't update `%s` config, because it's readonly config, the EMQX nodes will be rolled if it's changed", root))
+				continue
 			}
-			confStr = hoconConfig.String()
+			s.EventRecorder.Event(instance, corev1.EventTypeNormal, "WontUpdateReadOnlyConfig", fmt.Sprintf("Won't update `%s` config, because it's readonly config", root))
 		}
 
-		if err := putEMQXConfigsByAPI(r, instance.Spec.Config.Mode, confStr); err != nil {
-			return subResult{err: emperror.Wrap(err, "failed to put emqx config")}
+		if pushConfStr != "" {
+			if err := putEMQXConfigsByAPI(r, mode, pushConfStr); err != nil {
+				_ = s.updateConfigStatus(ctx, instance, config, removed, err)
+				return subResult{err: emperror.Wrap(err, "failed to put emqx config")}
+			}
+			s.EventRecorder.Event(instance, corev1.EventTypeNormal, "ConfigUpdated", getConfigUpdatedMessage(paths))
 		}
 
-		if err := s.Client.Update(ctx, generateConfigMap(instance, confStr)); err != nil {
+		configMap.Data[appliedConfigKey] = confStr
+		if err := s.Client.Update(ctx, configMap); err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to update configMap")}
 		}
 
 		instance.Annotations[appsv2beta1.AnnotationsLastEMQXConfigKey] = instance.Spec.Config.Data
+		setLastConfigSourcesHash(instance, sourcesHash)
 		if err := s.Client.Update(ctx, instance); err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
 		}
 
+		if err := s.updateConfigStatus(ctx, instance, config, removed, nil); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+		return subResult{}
+	}
+
+	if instance.Status.Config.ObservedHash == "" {
+		// The EMQX config was applied before the config status is introduced
+		if err := s.updateConfigStatus(ctx, instance, config, nil, nil); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+
+	if instance.Status.IsConditionTrue(appsv2beta1.CoreNodesReady) && r != nil {
+		return s.checkConfigDrift(ctx, logger, instance, r, config, confStr)
+	}
+	return subResult{}
+}
+
+// updateConfigStatus records the result of applying the EMQX config, the previous successful result is kept if it failed.
+func (s *syncConfig) updateConfigStatus(ctx context.Context, instance *appsv2beta1.EMQX, config string, skipped []string, applyErr error) error {
+	if applyErr != nil {
+		instance.Status.Config.LastError = applyErr.Error()
+	} else {
+		instance.Status.Config = appsv2beta1.EMQXConfigStatus{
+			ObservedHash:        computeStringHash(config),
+			LastAppliedTime:     ptr.To(metav1.Now()),
+			Mode:                instance.Spec.Config.Mode,
+			SkippedReadOnlyKeys: skipped,
+			LastDriftCheckTime:  instance.Status.Config.LastDriftCheckTime,
+		}
+	}
+	return s.Client.Status().Update(ctx, instance)
+}
+
+// checkConfigDrift compares the config of the running EMQX cluster with the EMQX config,
+// reports the drift in the ConfigDrifted condition, and re-applies the EMQX config if it's enforced.
+// It's checked at most once per configDriftCheckInterval.
+func (s *syncConfig) checkConfigDrift(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface, config, confStr string) subResult {
+	if !isConfigDriftCheckDue(instance, time.Now()) {
+		return subResult{}
+	}
+	instance.Status.Config.LastDriftCheckTime = ptr.To(metav1.Now())
+	if err := s.Client.Status().Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+
+	liveConfStr, err := getEMQXConfigsByAPI(r)
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to get emqx config")}
+	}
+	liveConfig, err := hocon.ParseString(liveConfStr)
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to parse emqx config")}
+	}
+
+	// The readonly configs can not be updated by the EMQX API, so they are not drifted
+	readOnlyRoots := []string{"node", "cluster", "rpc"}
+	if isReadOnlyDashboardConfig(instance) {
+		readOnlyRoots = append(readOnlyRoots, "dashboard")
+	}
+	desiredConfStr, _ := removeConfigRoots(config, readOnlyRoots)
+	desiredConfig, err := hocon.ParseString(desiredConfStr)
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to parse emqx config")}
+	}
+
+	drifted := diffConfig(desiredConfig.GetRoot().(hocon.Object), liveConfig.GetRoot().(hocon.Object))
+	// The config of the EMQX nodes role overrides the EMQX config on the EMQX nodes
+	drifted = slices.DeleteFunc(drifted, func(path string) bool {
+		return isRoleConfigPath(instance, path) || isEnvConfigPath(instance, path)
+	})
+	if len(drifted) == 0 {
+		if instance.Status.IsConditionTrue(appsv2beta1.ConfigDrifted) {
+			instance.Status.RemoveCondition(appsv2beta1.ConfigDrifted)
+			if err := s.Client.Status().Update(ctx, instance); err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update status")}
+			}
+		}
 		return subResult{}
 	}
 
+	message := fmt.Sprintf("%d config items of the EMQX cluster drifted from the EMQX config: %s", len(drifted), strings.Join(drifted, ", "))
+	if len(drifted) > 10 {
+		message = fmt.Sprintf("%d config items of the EMQX cluster drifted from the EMQX config: %s, ...", len(drifted), strings.Join(drifted[:10], ", "))
+	}
+	if _, condition := instance.Status.GetCondition(appsv2beta1.ConfigDrifted); condition == nil || condition.Message != message {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.ConfigDrifted, message)
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.ConfigDrifted,
+			Status:  metav1.ConditionTrue,
+			Reason:  appsv2beta1.ConfigDrifted,
+			Message: message,
+		})
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+
+	if instance.Spec.Config.Enforce {
+		logger.Info("config of the EMQX cluster drifted, will re-apply the EMQX config", "drifted", drifted)
+		var removed []string
+		if isReadOnlyDashboardConfig(instance) {
+			confStr, removed = removeConfigRoots(confStr, []string{"node", "cluster", "dashboard", "rpc"})
+		}
+		if err := putEMQXConfigsByAPI(r, instance.Spec.Config.Mode, confStr); err != nil {
+			_ = s.updateConfigStatus(ctx, instance, config, removed, err)
+			return subResult{err: emperror.Wrap(err, "failed to put emqx config")}
+		}
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "ConfigEnforced", "Re-applied the EMQX config to the EMQX cluster")
+		if err := s.updateConfigStatus(ctx, instance, config, removed, nil); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
 	return subResult{}
 }
 
+func isConfigDriftCheckDue(instance *appsv2beta1.EMQX, now time.Time) bool {
+	lastCheckTime := instance.Status.Config.LastDriftCheckTime
+	return lastCheckTime == nil || now.Sub(lastCheckTime.Time) >= configDriftCheckInterval
+}
+
 func generateConfigMap(instance *appsv2beta1.EMQX, data string) *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
@@ -123,6 +282,260 @@ func generateConfigMap(instance *appsv2beta1.EMQX, data string) *corev1.ConfigMa
 	}
 }
 
+// computeReadOnlyConfigHash returns the hash of the readonly config in .spec.config.data,
+// the `dashboard` config is readonly before EMQX 5.7.0, which is told from the image.
+func computeReadOnlyConfigHash(instance *appsv2beta1.EMQX) string {
+	roots := []string{"node", "cluster", "rpc"}
+	if v, _ := parseImageVersion(instance.Spec.Image); v != nil && v.LessThan(semver.MustParse("5.7.0")) {
+		roots = append(roots, "dashboard")
+	}
+
+	hoconConfig, err := hocon.ParseString(instance.Spec.Config.Data)
+	if err != nil {
+		return ""
+	}
+	readOnlyConfig := hocon.Object{}
+	for _, root := range roots {
+		if value, ok := hoconConfig.GetRoot().(hocon.Object)[root]; ok {
+			readOnlyConfig[root] = value
+		}
+	}
+	return computeStringHash(configValueString(readOnlyConfig))
+}
+
+// getConfigSources returns the HOCON fragments of the config sources, merged in order.
+func getConfigSources(ctx context.Context, k8sClient client.Client, instance *appsv2beta1.EMQX) (string, error) {
+	fragments := []string{}
+	for _, source := range instance.Spec.Config.Sources {
+		var name, key string
+		var optional *bool
+		var data map[string][]byte
+		switch {
+		case source.ConfigMapKeyRef != nil:
+			name, key, optional = source.ConfigMapKeyRef.Name, source.ConfigMapKeyRef.Key, source.ConfigMapKeyRef.Optional
+			configMap := &corev1.ConfigMap{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, configMap); err != nil {
+				if k8sErrors.IsNotFound(err) && ptr.Deref(optional, false) {
+					continue
+				}
+				return "", emperror.Wrapf(err, "failed to get configMap %s", name)
+			}
+			data = map[string][]byte{}
+			for k, v := range configMap.Data {
+				data[k] = []byte(v)
+			}
+		case source.SecretKeyRef != nil:
+			name, key, optional = source.SecretKeyRef.Name, source.SecretKeyRef.Key, source.SecretKeyRef.Optional
+			secret := &corev1.Secret{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+				if k8sErrors.IsNotFound(err) && ptr.Deref(optional, false) {
+					continue
+				}
+				return "", emperror.Wrapf(err, "failed to get secret %s", name)
+			}
+			data = secret.Data
+		default:
+			continue
+		}
+
+		fragment, ok := data[key]
+		if !ok {
+			if ptr.Deref(optional, false) {
+				continue
+			}
+			return "", emperror.Errorf("key %s not found in %s", key, name)
+		}
+		if _, err := hocon.ParseString(string(fragment)); err != nil {
+			return "", emperror.Wrapf(err, "key %s of %s is not a valid HOCON config", key, name)
+		}
+		fragments = append(fragments, string(fragment))
+	}
+	return strings.Join(fragments, "\n"), nil
+}
+
+// resolveSecretPlaceholders replaces the `${secret:name/key}` placeholders in the HOCON config with the values of the Secrets,
+// returns the resolved config and the values in order. The placeholders must be in quoted strings,
+// like `password = "${secret:db-creds/password}"`.
+func resolveSecretPlaceholders(ctx context.Context, k8sClient client.Client, instance *appsv2beta1.EMQX, config string) (string, []string, error) {
+	values := []string{}
+	var resolveErr error
+	resolved := secretPlaceholderRegexp.ReplaceAllStringFunc(config, func(placeholder string) string {
+		if resolveErr != nil {
+			return placeholder
+		}
+		match := secretPlaceholderRegexp.FindStringSubmatch(placeholder)
+		name, key := match[1], match[2]
+
+		secret := &corev1.Secret{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+			resolveErr = emperror.Wrapf(err, "failed to get secret %s", name)
+			return placeholder
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			resolveErr = emperror.Errorf("key %s not found in secret %s", key, name)
+			return placeholder
+		}
+		values = append(values, string(value))
+
+		// Escape the value for the quoted string
+		escaped, _ := json.Marshal(string(value))
+		return string(escaped[1 : len(escaped)-1])
+	})
+	if resolveErr != nil {
+		return "", nil, resolveErr
+	}
+	return resolved, values, nil
+}
+
+// getSecretPlaceholderNames returns the names of the Secrets referenced by the placeholders in the HOCON config.
+func getSecretPlaceholderNames(config string) []string {
+	names := []string{}
+	for _, match := range secretPlaceholderRegexp.FindAllStringSubmatch(config, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+func setLastConfigSourcesHash(instance *appsv2beta1.EMQX, sourcesHash string) {
+	if sourcesHash == "" {
+		delete(instance.Annotations, appsv2beta1.AnnotationsLastEMQXConfigSourcesHashKey)
+		return
+	}
+	instance.Annotations[appsv2beta1.AnnotationsLastEMQXConfigSourcesHashKey] = sourcesHash
+}
+
+// checkEnvConfigConflicts sets the ConfigOverriddenByEnv condition
+// if the EMQX_ environment variables of the EMQX nodes override the EMQX config,
+// because the overridden config can not be updated by the EMQX config or the EMQX API.
+func (s *syncConfig) checkEnvConfigConflicts(ctx context.Context, instance *appsv2beta1.EMQX, config string) error {
+	conflicts := appsv2beta1.GetEMQXEnvConfigConflicts(instance, config)
+	if len(conflicts) == 0 {
+		if instance.Status.IsConditionTrue(appsv2beta1.ConfigOverriddenByEnv) {
+			instance.Status.RemoveCondition(appsv2beta1.ConfigOverriddenByEnv)
+			return s.Client.Status().Update(ctx, instance)
+		}
+		return nil
+	}
+
+	message := fmt.Sprintf("The EMQX config is overridden by the EMQX_ environment variables: %s", strings.Join(conflicts, ", "))
+	if _, condition := instance.Status.GetCondition(appsv2beta1.ConfigOverriddenByEnv); condition == nil || condition.Message != message {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.ConfigOverriddenByEnv, message)
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.ConfigOverriddenByEnv,
+			Status:  metav1.ConditionTrue,
+			Reason:  appsv2beta1.ConfigOverriddenByEnv,
+			Message: message,
+		})
+		return s.Client.Status().Update(ctx, instance)
+	}
+	return nil
+}
+
+// getConfigUpdatedMessage returns the event message of the config paths pushed to the EMQX cluster,
+// the empty paths means the whole EMQX config is pushed.
+func getConfigUpdatedMessage(paths []string) string {
+	if len(paths) == 0 {
+		return "Updated the whole EMQX config"
+	}
+	if len(paths) > 10 {
+		return fmt.Sprintf("Updated the EMQX config: %s and %d more", strings.Join(paths[:10], ", "), len(paths)-10)
+	}
+	return fmt.Sprintf("Updated the EMQX config: %s", strings.Join(paths, ", "))
+}
+
+// getRoleConfig returns the config of the EMQX nodes role, or empty if it's not set.
+func getRoleConfig(roleConfig *appsv2beta1.RoleConfig) string {
+	if roleConfig == nil {
+		return ""
+	}
+	return roleConfig.Data
+}
+
+// isRoleConfigPath returns true if the config path is set in the config of any EMQX nodes role.
+func isRoleConfigPath(instance *appsv2beta1.EMQX, path string) bool {
+	roleConfigs := []string{getRoleConfig(instance.Spec.CoreTemplate.Config)}
+	if instance.Spec.ReplicantTemplate != nil {
+		roleConfigs = append(roleConfigs, getRoleConfig(instance.Spec.ReplicantTemplate.Config))
+	}
+	for _, roleConfig := range roleConfigs {
+		hoconConfig, err := hocon.ParseString(roleConfig)
+		if err != nil {
+			continue
+		}
+		values := map[string]hocon.Value{}
+		flattenConfig("", hoconConfig.GetRoot().(hocon.Object), values)
+		for rolePath := range values {
+			if path == rolePath || strings.HasPrefix(path, rolePath+".") || strings.HasPrefix(rolePath, path+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEnvConfigPath returns true if the config path is overridden by the EMQX_ environment variables of any EMQX nodes role.
+func isEnvConfigPath(instance *appsv2beta1.EMQX, path string) bool {
+	env := instance.Spec.CoreTemplate.Spec.Env
+	if instance.Spec.ReplicantTemplate != nil {
+		env = append(slices.Clone(env), instance.Spec.ReplicantTemplate.Spec.Env...)
+	}
+	for _, e := range env {
+		envPath, ok := appsv2beta1.GetEnvConfigPath(e.Name)
+		if !ok {
+			continue
+		}
+		if path == envPath || strings.HasPrefix(path, envPath+".") || strings.HasPrefix(envPath, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnlyDashboardConfig returns true if the `dashboard` config can not be updated by the EMQX API, before EMQX 5.7.0.
+func isReadOnlyDashboardConfig(instance *appsv2beta1.EMQX) bool {
+	if len(instance.Status.CoreNodes) == 0 {
+		return false
+	}
+	v, err := semver.NewVersion(instance.Status.CoreNodes[0].Version)
+	if err != nil {
+		return false
+	}
+	return v.LessThan(semver.MustParse("5.7.0"))
+}
+
+// removeConfigRoots removes the root keys from the HOCON config, and returns the removed ones.
+func removeConfigRoots(config string, roots []string) (string, []string) {
+	hoconConfig, err := hocon.ParseString(config)
+	if err != nil {
+		return config, nil
+	}
+	hoconConfigObj := hoconConfig.GetRoot().(hocon.Object)
+	removed := []string{}
+	for _, root := range roots {
+		if _, ok := hoconConfigObj[root]; ok {
+			delete(hoconConfigObj, root)
+			removed = append(removed, root)
+		}
+	}
+	return hoconConfig.String(), removed
+}
+
+func getEMQXConfigsByAPI(r innerReq.RequesterInterface) (string, error) {
+	url := r.GetURL("api/v5/configs")
+
+	resp, body, err := r.Request("GET", url, nil, http.Header{
+		"Accept": []string{"text/plain"},
+	})
+	if err != nil {
+		return "", emperror.Wrapf(err, "failed to get API %s", url.String())
+	}
+	if resp.StatusCode != 200 {
+		return "", emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+	return string(body), nil
+}
+
 func putEMQXConfigsByAPI(r innerReq.RequesterInterface, mode, config string) error {
 	url := r.GetURL("api/v5/configs", "mode="+strings.ToLower(mode), "ignore_readonly=true")
 
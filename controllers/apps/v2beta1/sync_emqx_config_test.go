@@ -1,11 +1,26 @@
 package v2beta1
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
 	"github.com/rory-z/go-hocon"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestMergeDefaultConfig(t *testing.T) {
@@ -36,3 +51,323 @@ func TestMergeDefaultConfig(t *testing.T) {
 		assert.Equal(t, "38084", hoconConfig.GetString("listeners.wss.default.bind"))
 	})
 }
+
+func TestRemoveConfigRoots(t *testing.T) {
+	got, removed := removeConfigRoots("node.cookie = emqx\nrpc.port_discovery = manual\nlog.console.level = warning", []string{"node", "cluster", "rpc"})
+	assert.Equal(t, []string{"node", "rpc"}, removed)
+	hoconConfig, err := hocon.ParseString(got)
+	assert.Nil(t, err)
+	assert.Equal(t, "", hoconConfig.GetString("node.cookie"))
+	assert.Equal(t, "warning", hoconConfig.GetString("log.console.level"))
+}
+
+func TestIsReadOnlyDashboardConfig(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	assert.False(t, isReadOnlyDashboardConfig(instance))
+
+	instance.Status.CoreNodes = []appsv2beta1.EMQXNode{{Version: "latest"}}
+	assert.False(t, isReadOnlyDashboardConfig(instance))
+
+	instance.Status.CoreNodes[0].Version = "5.6.1"
+	assert.True(t, isReadOnlyDashboardConfig(instance))
+
+	instance.Status.CoreNodes[0].Version = "5.7.0"
+	assert.False(t, isReadOnlyDashboardConfig(instance))
+}
+
+func TestGetConfigSources(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "base", Namespace: "emqx"},
+			Data:       map[string]string{"emqx.conf": "log.console.level = warning\nmqtt.max_topic_levels = 64"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "overlay", Namespace: "emqx"},
+			Data:       map[string][]byte{"emqx.conf": []byte("log.console.level = debug")},
+		},
+	).Build()
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+
+	t.Run("merge in order", func(t *testing.T) {
+		instance.Spec.Config.Sources = []appsv2beta1.ConfigSource{
+			{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "base"}, Key: "emqx.conf"}},
+			{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "overlay"}, Key: "emqx.conf"}},
+			{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "fake"}, Key: "emqx.conf", Optional: ptr.To(true)}},
+		}
+		got, err := getConfigSources(context.Background(), k8sClient, instance)
+		assert.Nil(t, err)
+		hoconConfig, err := hocon.ParseString(got)
+		assert.Nil(t, err)
+		assert.Equal(t, "debug", hoconConfig.GetString("log.console.level"))
+		assert.Equal(t, 64, hoconConfig.GetInt("mqtt.max_topic_levels"))
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		instance.Spec.Config.Sources = []appsv2beta1.ConfigSource{
+			{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "base"}, Key: "fake"}},
+		}
+		_, err := getConfigSources(context.Background(), k8sClient, instance)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveSecretPlaceholders(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-creds", Namespace: "emqx"},
+			Data:       map[string][]byte{"username": []byte("emqx"), "password": []byte(`pa"ss\word`)},
+		},
+	).Build()
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+
+	config := `authentication = [{backend = mysql, username = "${secret:db-creds/username}", password = "${secret:db-creds/password}"}]`
+	assert.Equal(t, []string{"db-creds", "db-creds"}, getSecretPlaceholderNames(config))
+
+	got, values, err := resolveSecretPlaceholders(context.Background(), k8sClient, instance, config)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"emqx", `pa"ss\word`}, values)
+	hoconConfig, err := hocon.ParseString(got)
+	assert.Nil(t, err)
+	assert.Equal(t, "emqx", hoconConfig.GetArray("authentication")[0].(hocon.Object)["username"].String())
+
+	_, _, err = resolveSecretPlaceholders(context.Background(), k8sClient, instance, `password = "${secret:db-creds/fake}"`)
+	assert.Error(t, err)
+	_, _, err = resolveSecretPlaceholders(context.Background(), k8sClient, instance, `password = "${secret:fake/password}"`)
+	assert.Error(t, err)
+}
+
+func TestUpdateConfigStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.Config.Mode = "Merge"
+
+	s := &syncConfig{
+		EMQXReconciler: &EMQXReconciler{
+			Handler: &handler.Handler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build(),
+			},
+		},
+	}
+
+	assert.Nil(t, s.updateConfigStatus(context.Background(), instance, "a = 1", []string{"node"}, nil))
+	assert.Equal(t, computeStringHash("a = 1"), instance.Status.Config.ObservedHash)
+	assert.NotNil(t, instance.Status.Config.LastAppliedTime)
+	assert.Equal(t, "Merge", instance.Status.Config.Mode)
+	assert.Equal(t, []string{"node"}, instance.Status.Config.SkippedReadOnlyKeys)
+
+	// The previous successful result is kept
+	assert.Nil(t, s.updateConfigStatus(context.Background(), instance, "a = 2", nil, errors.New("fake error")))
+	assert.Equal(t, computeStringHash("a = 1"), instance.Status.Config.ObservedHash)
+	assert.Equal(t, "fake error", instance.Status.Config.LastError)
+
+	assert.Nil(t, s.updateConfigStatus(context.Background(), instance, "a = 2", nil, nil))
+	assert.Equal(t, computeStringHash("a = 2"), instance.Status.Config.ObservedHash)
+	assert.Empty(t, instance.Status.Config.LastError)
+}
+
+func TestCheckConfigDrift(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Status.CoreNodes = []appsv2beta1.EMQXNode{{Version: "5.8.0"}}
+
+	s := &syncConfig{
+		EMQXReconciler: &EMQXReconciler{
+			Handler: &handler.Handler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build(),
+			},
+			EventRecorder: record.NewFakeRecorder(10),
+		},
+	}
+
+	requests := 0
+	r := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			requests++
+			return &http.Response{StatusCode: http.StatusOK}, []byte("log.console.level = debug"), nil
+		},
+	}
+	config := "log.console.level = warning"
+
+	assert.Nil(t, s.checkConfigDrift(context.Background(), logr.Discard(), instance, r, config, config).err)
+	assert.Equal(t, 1, requests)
+	assert.NotNil(t, instance.Status.Config.LastDriftCheckTime)
+	assert.True(t, instance.Status.IsConditionTrue(appsv2beta1.ConfigDrifted))
+
+	// The config of the running EMQX cluster is not fetched again within the interval
+	assert.Nil(t, s.checkConfigDrift(context.Background(), logr.Discard(), instance, r, config, config).err)
+	assert.Equal(t, 1, requests)
+
+	instance.Status.Config.LastDriftCheckTime = ptr.To(metav1.NewTime(time.Now().Add(-configDriftCheckInterval)))
+	assert.Nil(t, s.checkConfigDrift(context.Background(), logr.Discard(), instance, r, config, config).err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestComputeReadOnlyConfigHash(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	instance.Spec.Image = "emqx/emqx:5.8.0"
+	instance.Spec.Config.Data = "node.process_limit = 2097152\nmqtt.max_topic_levels = 64\ndashboard.token_expired_time = 60m"
+	got := computeReadOnlyConfigHash(instance)
+
+	// The changes of the other config don't change the hash
+	instance.Spec.Config.Data = "node.process_limit = 2097152\nmqtt.max_topic_levels = 128\ndashboard.token_expired_time = 30m"
+	assert.Equal(t, got, computeReadOnlyConfigHash(instance))
+
+	// The dashboard config is readonly before EMQX 5.7.0
+	instance.Spec.Image = "emqx/emqx:5.6.0"
+	assert.NotEqual(t, got, computeReadOnlyConfigHash(instance))
+
+	instance.Spec.Image = "emqx/emqx:5.8.0"
+	instance.Spec.Config.Data = "node.process_limit = 1048576"
+	assert.NotEqual(t, got, computeReadOnlyConfigHash(instance))
+
+	instance.Spec.Config.RollOnReadOnlyChange = true
+	sts := getNewStatefulSet(instance)
+	assert.Equal(t, computeReadOnlyConfigHash(instance), sts.Spec.Template.Annotations[appsv2beta1.AnnotationsReadOnlyConfigHashKey])
+}
+
+func TestIsRoleConfigPath(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	assert.False(t, isRoleConfigPath(instance, "node.process_limit"))
+
+	instance.Spec.ReplicantTemplate = &appsv2beta1.EMQXReplicantTemplate{
+		Config: &appsv2beta1.RoleConfig{Data: "mqtt.max_topic_levels = 64"},
+	}
+	assert.True(t, isRoleConfigPath(instance, "mqtt.max_topic_levels"))
+	assert.True(t, isRoleConfigPath(instance, "mqtt"))
+	assert.False(t, isRoleConfigPath(instance, "mqtt.max_qos_allowed"))
+
+	instance.Spec.CoreTemplate.Spec.Replicas = ptr.To(int32(1))
+	instance.Spec.ReplicantTemplate.Spec.Replicas = ptr.To(int32(1))
+	rs := getNewReplicaSet(instance)
+	assert.Contains(t, rs.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "bootstrap-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: instance.ReplicantConfigsNamespacedName().Name},
+			},
+		},
+	})
+	assert.Equal(t, computeStringHash("mqtt.max_topic_levels = 64"), rs.Spec.Template.Annotations[appsv2beta1.AnnotationsRoleConfigHashKey])
+	sts := getNewStatefulSet(instance)
+	assert.Contains(t, sts.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "bootstrap-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: instance.ConfigsNamespacedName().Name},
+			},
+		},
+	})
+	assert.NotContains(t, sts.Spec.Template.Annotations, appsv2beta1.AnnotationsRoleConfigHashKey)
+}
+
+func TestGetConfigUpdatedMessage(t *testing.T) {
+	assert.Equal(t, "Updated the whole EMQX config", getConfigUpdatedMessage(nil))
+	assert.Equal(t, "Updated the EMQX config: log.console.level, mqtt.max_topic_levels", getConfigUpdatedMessage([]string{"log.console.level", "mqtt.max_topic_levels"}))
+
+	paths := []string{}
+	for i := 0; i < 12; i++ {
+		paths = append(paths, fmt.Sprintf("a.b%02d", i))
+	}
+	assert.Equal(t, "Updated the EMQX config: a.b00, a.b01, a.b02, a.b03, a.b04, a.b05, a.b06, a.b07, a.b08, a.b09 and 2 more", getConfigUpdatedMessage(paths))
+}
+
+func TestCheckEnvConfigConflicts(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := appsv2beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.CoreTemplate.Spec.Env = []corev1.EnvVar{{Name: "EMQX_LISTENERS__TCP__DEFAULT__BIND", Value: "11883"}}
+
+	s := &syncConfig{
+		EMQXReconciler: &EMQXReconciler{
+			Handler: &handler.Handler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build(),
+			},
+			EventRecorder: record.NewFakeRecorder(10),
+		},
+	}
+
+	assert.Nil(t, s.checkEnvConfigConflicts(context.Background(), instance, "listeners.tcp.default.bind = 1883"))
+	_, condition := instance.Status.GetCondition(appsv2beta1.ConfigOverriddenByEnv)
+	assert.NotNil(t, condition)
+	assert.Contains(t, condition.Message, "EMQX_LISTENERS__TCP__DEFAULT__BIND overrides listeners.tcp.default.bind")
+	assert.True(t, isEnvConfigPath(instance, "listeners.tcp.default.bind"))
+	assert.False(t, isEnvConfigPath(instance, "listeners.ssl.default.bind"))
+
+	assert.Nil(t, s.checkEnvConfigConflicts(context.Background(), instance, "mqtt.max_topic_levels = 64"))
+	_, condition = instance.Status.GetCondition(appsv2beta1.ConfigOverriddenByEnv)
+	assert.Nil(t, condition)
+}
+
+func TestSyncConfigRemovedRoot(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.Config.Mode = "Merge"
+	instance.Spec.Config.Data = "mqtt.max_packet_size = 1MB\nlog.console.level = warning"
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.CoreNodesReady, Status: metav1.ConditionTrue})
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build()
+	s := &syncConfig{&EMQXReconciler{
+		Handler:       &handler.Handler{Client: k8sClient},
+		EventRecorder: record.NewFakeRecorder(10),
+	}}
+	ctx := context.Background()
+
+	_, appliedConfStr, _, err := s.renderEMQXConfig(ctx, instance)
+	assert.Nil(t, err)
+	assert.Nil(t, k8sClient.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.ConfigsNamespacedName().Name, Namespace: "emqx"},
+		Data:       map[string]string{appliedConfigKey: appliedConfStr},
+	}))
+	instance.Annotations = map[string]string{appsv2beta1.AnnotationsLastEMQXConfigKey: instance.Spec.Config.Data}
+
+	// The log root is removed from the EMQX config
+	instance.Spec.Config.Data = "mqtt.max_packet_size = 1MB"
+	assert.Nil(t, k8sClient.Update(ctx, instance))
+
+	var gotBody string
+	r := &innerReq.FakeRequester{
+		ReqFunc: func(method string, u url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			gotBody = string(body)
+			return &http.Response{StatusCode: http.StatusOK}, nil, nil
+		},
+	}
+	result := s.reconcile(ctx, logr.Discard(), instance, r)
+	assert.Nil(t, result.err)
+	// The whole EMQX config is pushed instead of the changed root keys, so the removed root is reset to the default value
+	assert.Contains(t, gotBody, "max_packet_size")
+	assert.Contains(t, gotBody, "listeners")
+	assert.NotContains(t, gotBody, "log.console.level")
+}
@@ -0,0 +1,256 @@
+package v2beta1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	preSwitchHook  = "pre-switch"
+	postSwitchHook = "post-switch"
+)
+
+// hookJobResyncPeriod blocks the update while the preSwitch hook Job is not succeeded.
+const hookJobResyncPeriod = 30 * time.Second
+
+type syncHooks struct {
+	*EMQXReconciler
+}
+
+func (s *syncHooks) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, _ innerReq.RequesterInterface) subResult {
+	if !isSwitching(instance) && instance.Status.IsConditionTrue(appsv2beta1.PreSwitchHookFailed) {
+		instance.Status.RemoveCondition(appsv2beta1.PreSwitchHookFailed)
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+
+	hooks := instance.Spec.UpdateStrategy.Hooks
+	if hooks == nil {
+		return subResult{}
+	}
+
+	if isSwitching(instance) {
+		revision := getSwitchRevision(instance)
+		if hooks.PostSwitch != nil && instance.Status.PostSwitchRevision != revision {
+			instance.Status.PostSwitchRevision = revision
+			if err := s.Client.Status().Update(ctx, instance); err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update status")}
+			}
+		}
+		if hooks.PreSwitch != nil {
+			return s.syncPreSwitchHook(ctx, logger, instance)
+		}
+		return subResult{}
+	}
+
+	if instance.Status.PostSwitchRevision != "" && isSwitched(instance) {
+		if hooks.PostSwitch != nil && instance.Status.PostSwitchRevision == getSwitchRevision(instance) {
+			return s.syncPostSwitchHook(ctx, logger, instance)
+		}
+		// The switch was reverted or the postSwitch hook was removed, nothing to run
+		instance.Status.PostSwitchRevision = ""
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+	return subResult{}
+}
+
+// syncPreSwitchHook blocks the update until the preSwitch hook Job succeeds.
+func (s *syncHooks) syncPreSwitchHook(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX) subResult {
+	job, err := s.getOrCreateHookJob(ctx, logger, instance, instance.Spec.UpdateStrategy.Hooks.PreSwitch, preSwitchHook)
+	if err != nil {
+		return subResult{err: err}
+	}
+
+	// The hook Jobs are watched, the EMQX custom resource is reconciled again when the Job is finished or the hook is changed
+	finished, succeeded := getJobFinishedStatus(job)
+	if !finished {
+		return subResult{result: ctrl.Result{RequeueAfter: hookJobResyncPeriod}}
+	}
+	if !succeeded {
+		message := fmt.Sprintf("PreSwitch hook job %s failed, the update is blocked", job.Name)
+		if _, condition := instance.Status.GetCondition(appsv2beta1.PreSwitchHookFailed); condition == nil || condition.Status != metav1.ConditionTrue || condition.Message != message {
+			s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.PreSwitchHookFailed, message)
+			instance.Status.SetCondition(metav1.Condition{
+				Type:    appsv2beta1.PreSwitchHookFailed,
+				Status:  metav1.ConditionTrue,
+				Reason:  "JobFailed",
+				Message: message,
+			})
+			if err := s.Client.Status().Update(ctx, instance); err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update status")}
+			}
+		}
+		return subResult{result: ctrl.Result{RequeueAfter: hookJobResyncPeriod}}
+	}
+
+	if instance.Status.IsConditionTrue(appsv2beta1.PreSwitchHookFailed) {
+		instance.Status.RemoveCondition(appsv2beta1.PreSwitchHookFailed)
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+	}
+	return subResult{}
+}
+
+// syncPostSwitchHook runs the postSwitch hook Job once, it does not block the reconcile.
+func (s *syncHooks) syncPostSwitchHook(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX) subResult {
+	job, err := s.getOrCreateHookJob(ctx, logger, instance, instance.Spec.UpdateStrategy.Hooks.PostSwitch, postSwitchHook)
+	if err != nil {
+		return subResult{err: err}
+	}
+
+	finished, succeeded := getJobFinishedStatus(job)
+	if !finished {
+		return subResult{}
+	}
+	if succeeded {
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "PostSwitchHookSucceeded", fmt.Sprintf("PostSwitch hook job %s succeeded", job.Name))
+	} else {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, "PostSwitchHookFailed", fmt.Sprintf("PostSwitch hook job %s failed", job.Name))
+	}
+
+	instance.Status.PostSwitchRevision = ""
+	if err := s.Client.Status().Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+	return subResult{}
+}
+
+func (s *syncHooks) getOrCreateHookJob(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, template *batchv1.JobTemplateSpec, hook string) (*batchv1.Job, error) {
+	job := generateHookJob(instance, template, hook)
+
+	storage := &batchv1.Job{}
+	err := s.Client.Get(ctx, client.ObjectKeyFromObject(job), storage)
+	if err == nil {
+		return storage, nil
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return nil, emperror.Wrapf(err, "failed to get %s hook job", hook)
+	}
+
+	logger.Info("create hook job for EMQX", "job", klog.KObj(job), "hook", hook)
+	_ = ctrl.SetControllerReference(instance, job, s.Scheme)
+	if err := s.Client.Create(ctx, job); err != nil {
+		return nil, emperror.Wrapf(err, "failed to create %s hook job", hook)
+	}
+	s.EventRecorder.Event(instance, corev1.EventTypeNormal, "HookJobCreated", fmt.Sprintf("Create %s hook job %s", hook, job.Name))
+
+	if err := s.deleteFailedHookJobs(ctx, logger, instance, hook, job.Name); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// deleteFailedHookJobs deletes the failed Jobs of the hook, which are replaced by the new Job,
+// e.g. the Job template was changed to retry the hook.
+func (s *syncHooks) deleteFailedHookJobs(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, hook, name string) error {
+	jobList := &batchv1.JobList{}
+	if err := s.Client.List(ctx, jobList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultLabels(instance), appsv2beta1.LabelsUpdateHookKey, hook)),
+	); err != nil {
+		return emperror.Wrapf(err, "failed to list %s hook jobs", hook)
+	}
+	for i := range jobList.Items {
+		job := &jobList.Items[i]
+		if job.Name == name || !metav1.IsControlledBy(job, instance) {
+			continue
+		}
+		if finished, succeeded := getJobFinishedStatus(job); !finished || succeeded {
+			continue
+		}
+		logger.Info("delete failed hook job for EMQX", "job", klog.KObj(job), "hook", hook)
+		if err := s.Client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !k8sErrors.IsNotFound(err) {
+			return emperror.Wrapf(err, "failed to delete %s hook job %s", hook, job.Name)
+		}
+	}
+	return nil
+}
+
+func generateHookJob(instance *appsv2beta1.EMQX, template *batchv1.JobTemplateSpec, hook string) *batchv1.Job {
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	// A new Job is created when the hook template is changed, so a failed hook can be retried by changing the template
+	templateData, _ := json.Marshal(template)
+	job.Name = fmt.Sprintf("%s-%s-%s", instance.Name, hook, computeStringHash(getSwitchRevision(instance)+string(templateData)))
+	job.Namespace = instance.Namespace
+	job.Labels = appsv2beta1.CloneAndMergeMap(appsv2beta1.DefaultLabels(instance), job.Labels)
+	job.Labels[appsv2beta1.LabelsUpdateHookKey] = hook
+
+	// Let the hook know which EMQX nodes are the new ones
+	env := []corev1.EnvVar{
+		{Name: "EMQX_CORE_UPDATE_REVISION", Value: instance.Status.CoreNodesStatus.UpdateRevision},
+	}
+	if appsv2beta1.IsExistReplicant(instance) {
+		env = append(env, corev1.EnvVar{Name: "EMQX_REPLICANT_UPDATE_REVISION", Value: instance.Status.ReplicantNodesStatus.UpdateRevision})
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		job.Spec.Template.Spec.Containers[i].Env = append(append([]corev1.EnvVar{}, env...), job.Spec.Template.Spec.Containers[i].Env...)
+	}
+	return job
+}
+
+// getJobFinishedStatus returns whether the job is finished, and whether it succeeded.
+func getJobFinishedStatus(job *batchv1.Job) (finished, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// getSwitchRevision returns the update revisions of the EMQX nodes, which identify the switch of an update.
+func getSwitchRevision(instance *appsv2beta1.EMQX) string {
+	revision := instance.Status.CoreNodesStatus.UpdateRevision
+	if appsv2beta1.IsExistReplicant(instance) {
+		revision += "-" + instance.Status.ReplicantNodesStatus.UpdateRevision
+	}
+	return revision
+}
+
+// isSwitching returns true if the new EMQX nodes are ready, but the old EMQX nodes are not scaled down yet.
+func isSwitching(instance *appsv2beta1.EMQX) bool {
+	if !instance.Status.IsConditionTrue(appsv2beta1.Available) {
+		return false
+	}
+	if instance.Status.CoreNodesStatus.UpdateRevision != instance.Status.CoreNodesStatus.CurrentRevision {
+		return true
+	}
+	return appsv2beta1.IsExistReplicant(instance) &&
+		instance.Status.ReplicantNodesStatus.UpdateRevision != instance.Status.ReplicantNodesStatus.CurrentRevision
+}
+
+// isSwitched returns true if the old EMQX nodes are scaled down to zero, and the EMQX cluster is ready.
+func isSwitched(instance *appsv2beta1.EMQX) bool {
+	return instance.Status.IsConditionTrue(appsv2beta1.Ready) && !isSwitching(instance)
+}
@@ -0,0 +1,180 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGenerateHookJob(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx",
+			Namespace: "emqx",
+		},
+		Spec: appsv2beta1.EMQXSpec{
+			ReplicantTemplate: &appsv2beta1.EMQXReplicantTemplate{
+				Spec: appsv2beta1.EMQXReplicantTemplateSpec{
+					Replicas: ptr.To(int32(3)),
+				},
+			},
+		},
+	}
+	instance.Status.CoreNodesStatus.UpdateRevision = "core"
+	instance.Status.ReplicantNodesStatus.UpdateRevision = "repl"
+
+	template := &batchv1.JobTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"foo": "bar"},
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{Name: "smoke-test", Image: "busybox", Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}}},
+					},
+				},
+			},
+		},
+	}
+
+	got := generateHookJob(instance, template, preSwitchHook)
+	assert.Regexp(t, "^emqx-pre-switch-", got.Name)
+	assert.Equal(t, "emqx", got.Namespace)
+	assert.Equal(t, "bar", got.Labels["foo"])
+	assert.Equal(t, "emqx", got.Labels[appsv2beta1.LabelsInstanceKey])
+	assert.Equal(t, preSwitchHook, got.Labels[appsv2beta1.LabelsUpdateHookKey])
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "EMQX_CORE_UPDATE_REVISION", Value: "core"},
+		{Name: "EMQX_REPLICANT_UPDATE_REVISION", Value: "repl"},
+		{Name: "FOO", Value: "bar"},
+	}, got.Spec.Template.Spec.Containers[0].Env)
+	// The template is not changed
+	assert.Len(t, template.Spec.Template.Spec.Containers[0].Env, 1)
+
+	// The Job name is stable for the same switch and template
+	assert.Equal(t, got.Name, generateHookJob(instance, template, preSwitchHook).Name)
+
+	// A new Job is generated when the template is changed
+	changed := template.DeepCopy()
+	changed.Spec.Template.Spec.Containers[0].Image = "alpine"
+	assert.NotEqual(t, got.Name, generateHookJob(instance, changed, preSwitchHook).Name)
+
+	// A new Job is generated for a new switch
+	newSwitch := instance.DeepCopy()
+	newSwitch.Status.CoreNodesStatus.UpdateRevision = "new-core"
+	assert.NotEqual(t, got.Name, generateHookJob(newSwitch, template, preSwitchHook).Name)
+}
+
+func TestSyncPreSwitchHookRetry(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "emqx-uid"},
+	}
+	instance.Spec.UpdateStrategy.Hooks = &appsv2beta1.UpdateHooks{
+		PreSwitch: &batchv1.JobTemplateSpec{
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyNever,
+						Containers:    []corev1.Container{{Name: "smoke-test", Image: "busybox"}},
+					},
+				},
+			},
+		},
+	}
+	instance.Status.CoreNodesStatus.UpdateRevision = "update"
+	instance.Status.CoreNodesStatus.CurrentRevision = "current"
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build()
+	s := &syncHooks{&EMQXReconciler{
+		Handler:       &handler.Handler{Client: k8sClient},
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(10),
+	}}
+	ctx := context.Background()
+
+	// The update is blocked until the Job is finished
+	result := s.syncPreSwitchHook(ctx, logr.Discard(), instance)
+	assert.Nil(t, result.err)
+	assert.Equal(t, hookJobResyncPeriod, result.result.RequeueAfter)
+
+	failedJob := &batchv1.Job{}
+	assert.Nil(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(generateHookJob(instance, instance.Spec.UpdateStrategy.Hooks.PreSwitch, preSwitchHook)), failedJob))
+	failedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	assert.Nil(t, k8sClient.Status().Update(ctx, failedJob))
+
+	result = s.syncPreSwitchHook(ctx, logr.Discard(), instance)
+	assert.Nil(t, result.err)
+	assert.Equal(t, hookJobResyncPeriod, result.result.RequeueAfter)
+	assert.True(t, instance.Status.IsConditionTrue(appsv2beta1.PreSwitchHookFailed))
+
+	// Changing the template retries the hook, and the failed Job is deleted
+	instance.Spec.UpdateStrategy.Hooks.PreSwitch.Spec.Template.Spec.Containers[0].Image = "alpine"
+	result = s.syncPreSwitchHook(ctx, logr.Discard(), instance)
+	assert.Nil(t, result.err)
+
+	jobList := &batchv1.JobList{}
+	assert.Nil(t, k8sClient.List(ctx, jobList))
+	assert.Len(t, jobList.Items, 1)
+	assert.NotEqual(t, failedJob.Name, jobList.Items[0].Name)
+	assert.Equal(t, "alpine", jobList.Items[0].Spec.Template.Spec.Containers[0].Image)
+
+	// The update continues when the new Job succeeds
+	jobList.Items[0].Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	assert.Nil(t, k8sClient.Status().Update(ctx, &jobList.Items[0]))
+	result = s.syncPreSwitchHook(ctx, logr.Discard(), instance)
+	assert.Nil(t, result.err)
+	assert.True(t, result.result.IsZero())
+	assert.False(t, instance.Status.IsConditionTrue(appsv2beta1.PreSwitchHookFailed))
+}
+
+func TestGetJobFinishedStatus(t *testing.T) {
+	job := &batchv1.Job{}
+	finished, _ := getJobFinishedStatus(job)
+	assert.False(t, finished)
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	finished, succeeded := getJobFinishedStatus(job)
+	assert.True(t, finished)
+	assert.False(t, succeeded)
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	finished, succeeded = getJobFinishedStatus(job)
+	assert.True(t, finished)
+	assert.True(t, succeeded)
+}
+
+func TestIsSwitching(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	instance.Status.CoreNodesStatus.UpdateRevision = "new"
+	instance.Status.CoreNodesStatus.CurrentRevision = "old"
+	assert.False(t, isSwitching(instance))
+
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Available, Status: metav1.ConditionTrue})
+	assert.True(t, isSwitching(instance))
+	assert.False(t, isSwitched(instance))
+
+	instance.Status.CoreNodesStatus.CurrentRevision = "new"
+	assert.False(t, isSwitching(instance))
+	assert.False(t, isSwitched(instance))
+
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Ready, Status: metav1.ConditionTrue})
+	assert.True(t, isSwitched(instance))
+}
@@ -0,0 +1,219 @@
+package v2beta1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	"github.com/sethvargo/go-password/password"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The key of the node cookie secret for the Erlang VM flags of the previous node cookie, it's set until the node cookie is rotated
+const nodeCookieErlFlagsKey = "erl_flags"
+
+type syncNodeCookie struct {
+	*EMQXReconciler
+}
+
+// reconcile rotates the node cookie when the `node.cookie` of the EMQX config is changed, or it's requested by the annotation.
+// The EMQX nodes with different cookies cannot form a cluster, so the rotated node cookie is rolled out by the blue-green update:
+// the hash of the node cookie is set in the pod template to create the new statefulSet and replicaSet,
+// and the new EMQX nodes connect to the old EMQX nodes with the previous node cookie by the `-setcookie Node Cookie` flags
+// of the Erlang VM, which are kept in the node cookie secret until all the EMQX nodes are rolled.
+func (s *syncNodeCookie) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, _ innerReq.RequesterInterface) subResult {
+	secret := &corev1.Secret{}
+	if err := s.Client.Get(ctx, instance.NodeCookieNamespacedName(), secret); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to get node cookie secret")}
+	}
+
+	if _, ok := secret.Data[nodeCookieErlFlagsKey]; !ok {
+		cookie, err := s.getRotatedNodeCookie(ctx, instance, string(secret.Data["node_cookie"]))
+		if err != nil {
+			return subResult{err: err}
+		}
+		if cookie == "" {
+			if _, condition := instance.Status.GetCondition(appsv2beta1.NodeCookieRotating); condition != nil {
+				instance.Status.RemoveCondition(appsv2beta1.NodeCookieRotating)
+				if err := s.Client.Status().Update(ctx, instance); err != nil {
+					return subResult{err: emperror.Wrap(err, "failed to update status")}
+				}
+			}
+			return subResult{}
+		}
+		if isInPlaceUpdate(instance) {
+			// The in-place updated EMQX core nodes keep their names, so the previous node cookie can not be set for the old EMQX nodes only
+			return subResult{err: s.updateNodeCookieRotatingCondition(ctx, instance, metav1.ConditionFalse, "InPlaceUpdate",
+				`the node cookie can not be rotated by the in-place update, set ".spec.updateStrategy.coreUpdateMode" to BlueGreen`)}
+		}
+
+		logger.Info("rotate the node cookie by the blue-green update")
+		secret.Data[nodeCookieErlFlagsKey] = []byte(getPreviousNodeCookieFlags(instance, string(secret.Data["node_cookie"])))
+		secret.Data["node_cookie"] = []byte(cookie)
+		if err := s.Client.Update(ctx, secret); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update node cookie secret")}
+		}
+	}
+
+	// The node cookie hash is set after the node cookie secret is updated, so the EMQX nodes are rolled even if the operator restarts in between
+	hash := computeStringHash(string(secret.Data["node_cookie"]))
+	if instance.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey] != hash {
+		instance.Annotations = appsv2beta1.CloneAndAddLabel(instance.Annotations, appsv2beta1.AnnotationsNodeCookieHashKey, hash)
+		delete(instance.Annotations, appsv2beta1.AnnotationsRotateNodeCookieKey)
+		if err := s.Client.Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
+		}
+	}
+
+	rolled, total, err := s.countNodeCookieRolledNodes(ctx, instance, hash)
+	if err != nil {
+		return subResult{err: err}
+	}
+	if rolled < total || total == 0 || !instance.Status.IsConditionTrue(appsv2beta1.Ready) {
+		message := fmt.Sprintf("%d of %d EMQX nodes are rolled to the rotated node cookie", rolled, total)
+		return subResult{err: s.updateNodeCookieRotatingCondition(ctx, instance, metav1.ConditionTrue, "RollingEMQXNodes", message)}
+	}
+
+	delete(secret.Data, nodeCookieErlFlagsKey)
+	if err := s.Client.Update(ctx, secret); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update node cookie secret")}
+	}
+	instance.Status.RemoveCondition(appsv2beta1.NodeCookieRotating)
+	if err := s.Client.Status().Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+	s.EventRecorder.Event(instance, corev1.EventTypeNormal, "NodeCookieRotated", "Rotated the node cookie and rolled all the EMQX nodes")
+	return subResult{}
+}
+
+// getRotatedNodeCookie returns the node cookie to rotate to, or an empty string if the node cookie is not rotated.
+func (s *syncNodeCookie) getRotatedNodeCookie(ctx context.Context, instance *appsv2beta1.EMQX, current string) (string, error) {
+	config, _, _, err := s.renderEMQXConfig(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	cookie := appsv2beta1.GetNodeCookie(config)
+	if _, ok := instance.Annotations[appsv2beta1.AnnotationsRotateNodeCookieKey]; ok {
+		if cookie == "" {
+			cookie, _ = password.Generate(64, 10, 0, true, true)
+			return cookie, nil
+		}
+		if cookie == current {
+			s.EventRecorder.Event(instance, corev1.EventTypeWarning, "NodeCookieRotationIgnored",
+				`The node cookie is set by "node.cookie" in the EMQX config, remove it to rotate the node cookie to a random one`)
+			delete(instance.Annotations, appsv2beta1.AnnotationsRotateNodeCookieKey)
+			if err := s.Client.Update(ctx, instance); err != nil {
+				return "", emperror.Wrap(err, "failed to update emqx instance annotation")
+			}
+		}
+	}
+	if cookie == current {
+		return "", nil
+	}
+	return cookie, nil
+}
+
+// countNodeCookieRolledNodes returns the number of the EMQX nodes whose statefulSet or replicaSet has the node cookie hash in the pod template,
+// and the number of all the EMQX nodes.
+func (s *syncNodeCookie) countNodeCookieRolledNodes(ctx context.Context, instance *appsv2beta1.EMQX, hash string) (int, int, error) {
+	rolledSets := []types.UID{}
+	stsList := &appsv1.StatefulSetList{}
+	if err := s.Client.List(ctx, stsList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultCoreLabels(instance)),
+	); err != nil {
+		return 0, 0, emperror.Wrap(err, "failed to list statefulSets")
+	}
+	for _, sts := range stsList.Items {
+		if sts.Spec.Template.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey] == hash {
+			rolledSets = append(rolledSets, sts.UID)
+		}
+	}
+	rsList := &appsv1.ReplicaSetList{}
+	if err := s.Client.List(ctx, rsList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultReplicantLabels(instance)),
+	); err != nil {
+		return 0, 0, emperror.Wrap(err, "failed to list replicaSets")
+	}
+	for _, rs := range rsList.Items {
+		if rs.Spec.Template.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey] == hash {
+			rolledSets = append(rolledSets, rs.UID)
+		}
+	}
+
+	nodes := append(slices.Clone(instance.Status.CoreNodes), instance.Status.ReplicantNodes...)
+	rolled := 0
+	for _, node := range nodes {
+		if slices.Contains(rolledSets, node.ControllerUID) {
+			rolled++
+		}
+	}
+	return rolled, len(nodes), nil
+}
+
+func (s *syncNodeCookie) updateNodeCookieRotatingCondition(ctx context.Context, instance *appsv2beta1.EMQX, status metav1.ConditionStatus, reason, message string) error {
+	if _, condition := instance.Status.GetCondition(appsv2beta1.NodeCookieRotating); condition != nil && condition.Status == status && condition.Message == message {
+		return nil
+	}
+	if status == metav1.ConditionFalse {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.NodeCookieRotating, message)
+	}
+	instance.Status.SetCondition(metav1.Condition{
+		Type:    appsv2beta1.NodeCookieRotating,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	if err := s.Client.Status().Update(ctx, instance); err != nil {
+		return emperror.Wrap(err, "failed to update status")
+	}
+	return nil
+}
+
+// getPreviousNodeCookieFlags returns the `-setcookie Node Cookie` flags of the Erlang VM,
+// so the new EMQX nodes connect to the running EMQX nodes with the previous node cookie.
+func getPreviousNodeCookieFlags(instance *appsv2beta1.EMQX, cookie string) string {
+	// The quoted arguments of ERL_FLAGS are unquoted by erlexec
+	quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(cookie) + `"`
+	flags := []string{}
+	for _, node := range append(slices.Clone(instance.Status.CoreNodes), instance.Status.ReplicantNodes...) {
+		flags = append(flags, "-setcookie", node.Node, quoted)
+	}
+	return strings.Join(flags, " ")
+}
+
+// addNodeCookieRotation sets the node cookie hash in the pod template to roll the EMQX nodes when the node cookie is rotated,
+// and passes the flags of the previous node cookie to the Erlang VM, see syncNodeCookie.
+func addNodeCookieRotation(instance *appsv2beta1.EMQX, template *corev1.PodTemplateSpec) {
+	hash, ok := instance.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey]
+	if !ok {
+		return
+	}
+	template.Annotations = appsv2beta1.CloneAndAddLabel(template.Annotations, appsv2beta1.AnnotationsNodeCookieHashKey, hash)
+	// The environment variables of the EMQX spec are appended later, so they can still override it
+	template.Spec.Containers[0].Env = append([]corev1.EnvVar{
+		{
+			Name: "ERL_FLAGS",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: instance.NodeCookieNamespacedName().Name,
+					},
+					Key:      nodeCookieErlFlagsKey,
+					Optional: ptr.To(true),
+				},
+			},
+		},
+	}, template.Spec.Containers[0].Env...)
+}
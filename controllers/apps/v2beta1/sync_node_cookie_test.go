@@ -0,0 +1,209 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncNodeCookie(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	newInstance := func() *appsv2beta1.EMQX {
+		instance := &appsv2beta1.EMQX{ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"}}
+		instance.Status.CoreNodes = []appsv2beta1.EMQXNode{
+			{Node: "emqx@emqx-core-old-0.emqx-headless.emqx.svc.cluster.local", ControllerUID: "old"},
+		}
+		instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Ready, Status: metav1.ConditionTrue})
+		return instance
+	}
+	newTestCase := func(instance *appsv2beta1.EMQX, objs ...client.Object) (*syncNodeCookie, client.Client, *record.FakeRecorder) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: instance.NodeCookieNamespacedName().Name, Namespace: "emqx"},
+			Data:       map[string][]byte{"node_cookie": []byte("foo")},
+		}
+		source := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "base", Namespace: "emqx"},
+			Data:       map[string]string{"emqx.conf": `node.cookie = "baz"`},
+		}
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(append([]client.Object{instance, source, secret}, objs...)...).
+			WithStatusSubresource(instance).
+			Build()
+		recorder := record.NewFakeRecorder(10)
+		return &syncNodeCookie{&EMQXReconciler{
+			Handler:       &handler.Handler{Client: k8sClient},
+			Scheme:        scheme,
+			EventRecorder: recorder,
+		}}, k8sClient, recorder
+	}
+	getSecret := func(k8sClient client.Client, instance *appsv2beta1.EMQX) *corev1.Secret {
+		secret := &corev1.Secret{}
+		assert.Nil(t, k8sClient.Get(context.Background(), instance.NodeCookieNamespacedName(), secret))
+		return secret
+	}
+	getInstance := func(k8sClient client.Client, instance *appsv2beta1.EMQX) *appsv2beta1.EMQX {
+		got := &appsv2beta1.EMQX{}
+		assert.Nil(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(instance), got))
+		return got
+	}
+
+	t.Run("node cookie not changed", func(t *testing.T) {
+		instance := newInstance()
+		instance.Spec.Config.Data = `node.cookie = "foo"`
+		s, k8sClient, _ := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		assert.True(t, result.result.IsZero())
+		secret := getSecret(k8sClient, instance)
+		assert.Equal(t, "foo", string(secret.Data["node_cookie"]))
+		assert.NotContains(t, secret.Data, nodeCookieErlFlagsKey)
+		got := getInstance(k8sClient, instance)
+		assert.NotContains(t, got.Annotations, appsv2beta1.AnnotationsNodeCookieHashKey)
+		_, condition := got.Status.GetCondition(appsv2beta1.NodeCookieRotating)
+		assert.Nil(t, condition)
+	})
+
+	t.Run("node cookie changed", func(t *testing.T) {
+		instance := newInstance()
+		instance.Spec.Config.Data = `node.cookie = "bar"`
+		s, k8sClient, _ := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		assert.True(t, result.result.IsZero())
+		secret := getSecret(k8sClient, instance)
+		assert.Equal(t, "bar", string(secret.Data["node_cookie"]))
+		// The new EMQX nodes connect to the old EMQX nodes with the previous node cookie
+		assert.Equal(t, `-setcookie emqx@emqx-core-old-0.emqx-headless.emqx.svc.cluster.local "foo"`, string(secret.Data[nodeCookieErlFlagsKey]))
+
+		got := getInstance(k8sClient, instance)
+		assert.Equal(t, computeStringHash("bar"), got.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey])
+		_, condition := got.Status.GetCondition(appsv2beta1.NodeCookieRotating)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "0 of 1 EMQX nodes are rolled to the rotated node cookie", condition.Message)
+
+		// The node cookie hash in the pod template creates the new statefulSet
+		sts := getNewStatefulSet(got)
+		assert.Equal(t, computeStringHash("bar"), sts.Spec.Template.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey])
+		assert.Contains(t, sts.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name: "ERL_FLAGS",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: instance.NodeCookieNamespacedName().Name},
+					Key:                  nodeCookieErlFlagsKey,
+					Optional:             ptr.To(true),
+				},
+			},
+		})
+		previous := newInstance()
+		previous.Spec.Config.Data = `node.cookie = "bar"`
+		assert.NotEqual(t, getNewStatefulSet(previous).Name, sts.Name)
+	})
+
+	t.Run("node cookie changed by config sources", func(t *testing.T) {
+		instance := newInstance()
+		instance.Spec.Config.Sources = []appsv2beta1.ConfigSource{
+			{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "base"}, Key: "emqx.conf"}},
+		}
+		s, k8sClient, _ := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		assert.Equal(t, "baz", string(getSecret(k8sClient, instance).Data["node_cookie"]))
+		assert.Equal(t, computeStringHash("baz"), getInstance(k8sClient, instance).Annotations[appsv2beta1.AnnotationsNodeCookieHashKey])
+	})
+
+	t.Run("rotate by annotation", func(t *testing.T) {
+		instance := newInstance()
+		instance.Annotations = map[string]string{appsv2beta1.AnnotationsRotateNodeCookieKey: ""}
+		s, k8sClient, _ := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		cookie := string(getSecret(k8sClient, instance).Data["node_cookie"])
+		assert.NotEqual(t, "foo", cookie)
+		assert.Len(t, cookie, 64)
+
+		got := getInstance(k8sClient, instance)
+		assert.NotContains(t, got.Annotations, appsv2beta1.AnnotationsRotateNodeCookieKey)
+		assert.Equal(t, computeStringHash(cookie), got.Annotations[appsv2beta1.AnnotationsNodeCookieHashKey])
+	})
+
+	t.Run("rotate by annotation when the node cookie is set in the EMQX config", func(t *testing.T) {
+		instance := newInstance()
+		instance.Annotations = map[string]string{appsv2beta1.AnnotationsRotateNodeCookieKey: ""}
+		instance.Spec.Config.Data = `node.cookie = "foo"`
+		s, k8sClient, recorder := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		secret := getSecret(k8sClient, instance)
+		assert.Equal(t, "foo", string(secret.Data["node_cookie"]))
+		assert.NotContains(t, secret.Data, nodeCookieErlFlagsKey)
+		assert.NotContains(t, getInstance(k8sClient, instance).Annotations, appsv2beta1.AnnotationsRotateNodeCookieKey)
+		assert.Contains(t, <-recorder.Events, "NodeCookieRotationIgnored")
+	})
+
+	t.Run("in-place update", func(t *testing.T) {
+		instance := newInstance()
+		instance.Spec.Config.Data = `node.cookie = "bar"`
+		instance.Spec.UpdateStrategy.CoreUpdateMode = appsv2beta1.CoreUpdateModeInPlace
+		s, k8sClient, _ := newTestCase(instance)
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		assert.Equal(t, "foo", string(getSecret(k8sClient, instance).Data["node_cookie"]))
+		_, condition := getInstance(k8sClient, instance).Status.GetCondition(appsv2beta1.NodeCookieRotating)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "InPlaceUpdate", condition.Reason)
+	})
+
+	t.Run("rotation finished", func(t *testing.T) {
+		instance := newInstance()
+		instance.Annotations = map[string]string{appsv2beta1.AnnotationsNodeCookieHashKey: computeStringHash("foo")}
+		instance.Spec.Config.Data = `node.cookie = "foo"`
+		instance.Status.CoreNodes[0].ControllerUID = "new"
+		instance.Status.SetCondition(metav1.Condition{
+			Type:   appsv2beta1.NodeCookieRotating,
+			Status: metav1.ConditionTrue,
+			Reason: "RollingEMQXNodes",
+		})
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "emqx-core-new", Namespace: "emqx", UID: "new", Labels: appsv2beta1.DefaultCoreLabels(instance)},
+			Spec: appsv1.StatefulSetSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{appsv2beta1.AnnotationsNodeCookieHashKey: computeStringHash("foo")}},
+				},
+			},
+		}
+		s, k8sClient, _ := newTestCase(instance, sts)
+		secret := getSecret(k8sClient, instance)
+		secret.Data[nodeCookieErlFlagsKey] = []byte(`-setcookie emqx@emqx-core-old-0.emqx-headless.emqx.svc.cluster.local "bar"`)
+		assert.Nil(t, k8sClient.Update(context.Background(), secret))
+
+		result := s.reconcile(context.Background(), logr.Discard(), instance, nil)
+		assert.Nil(t, result.err)
+		secret = getSecret(k8sClient, instance)
+		assert.Equal(t, "foo", string(secret.Data["node_cookie"]))
+		assert.NotContains(t, secret.Data, nodeCookieErlFlagsKey)
+		_, condition := getInstance(k8sClient, instance).Status.GetCondition(appsv2beta1.NodeCookieRotating)
+		assert.Nil(t, condition)
+	})
+}
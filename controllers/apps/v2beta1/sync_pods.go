@@ -4,17 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	neturl "net/url"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	emperror "emperror.dev/errors"
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
+	"github.com/tidwall/gjson"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -32,6 +39,11 @@ func (s *syncPods) reconcile(ctx context.Context, logger logr.Logger, instance *
 		return subResult{}
 	}
 
+	// The Paused condition is set by syncRollback
+	if instance.Spec.UpdateStrategy.Paused {
+		return subResult{}
+	}
+
 	updateSts, currentSts, _ := getStateFulSetList(ctx, s.Client, instance)
 	updateRs, currentRs, _ := getReplicaSetList(ctx, s.Client, instance)
 
@@ -55,6 +67,34 @@ func (s *syncPods) reconcile(ctx context.Context, logger logr.Logger, instance *
 	}
 
 	if updateRs != nil && currentRs != nil && updateRs.UID != currentRs.UID {
+		if isRollingUpdate(instance) && appsv2beta1.IsExistReplicant(instance) && !checkRollingUpdateMaxUnavailable(instance, updateRs, currentRs) {
+			return subResult{}
+		}
+		if appsv2beta1.IsExistReplicant(instance) {
+			desired := *instance.Spec.ReplicantTemplate.Spec.Replicas
+			hold, err := s.holdByCanaryStep(ctx, instance, instance.Status.ReplicantNodesStatus.UpdateRevision, desired, desired-*currentRs.Spec.Replicas)
+			if err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update canary status")}
+			}
+			if hold {
+				return subResult{}
+			}
+		}
+		if appsv2beta1.IsExistReplicant(instance) {
+			oldEMQXNodesName := []string{}
+			for _, node := range instance.Status.ReplicantNodes {
+				if node.ControllerUID == currentRs.UID {
+					oldEMQXNodesName = append(oldEMQXNodesName, node.Node)
+				}
+			}
+			hold, err := s.holdByAnalysis(ctx, logger, instance, r, "replicant", targetedEMQXNodesName, oldEMQXNodesName)
+			if err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to analyze replicant nodes")}
+			}
+			if hold {
+				return subResult{}
+			}
+		}
 		shouldDeletePod, err := s.canBeScaleDownRs(ctx, instance, r, currentRs, targetedEMQXNodesName)
 		if err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to check if pod can be scale down")}
@@ -87,7 +127,37 @@ func (s *syncPods) reconcile(ctx context.Context, logger logr.Logger, instance *
 		return subResult{}
 	}
 
+	if updateSts != nil && currentSts != nil && updateSts.UID == currentSts.UID && getStatefulSetPartition(updateSts) > 0 {
+		return s.moveInPlacePartition(ctx, logger, instance, updateSts)
+	}
+
 	if updateSts != nil && currentSts != nil && updateSts.UID != currentSts.UID {
+		if !appsv2beta1.IsExistReplicant(instance) {
+			desired := *instance.Spec.CoreTemplate.Spec.Replicas
+			hold, err := s.holdByCanaryStep(ctx, instance, instance.Status.CoreNodesStatus.UpdateRevision, desired, desired-*currentSts.Spec.Replicas)
+			if err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update canary status")}
+			}
+			if hold {
+				return subResult{}
+			}
+		}
+		newEMQXNodesName, oldEMQXNodesName := []string{}, []string{}
+		for _, node := range instance.Status.CoreNodes {
+			switch node.ControllerUID {
+			case updateSts.UID:
+				newEMQXNodesName = append(newEMQXNodesName, node.Node)
+			case currentSts.UID:
+				oldEMQXNodesName = append(oldEMQXNodesName, node.Node)
+			}
+		}
+		hold, err := s.holdByAnalysis(ctx, logger, instance, r, "core", newEMQXNodesName, oldEMQXNodesName)
+		if err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to analyze core nodes")}
+		}
+		if hold {
+			return subResult{}
+		}
 		canBeScaledDown, err := s.canBeScaleDownSts(ctx, instance, r, currentSts, targetedEMQXNodesName)
 		if err != nil {
 			return subResult{err: emperror.Wrap(err, "failed to check if sts can be scale down")}
@@ -101,9 +171,115 @@ func (s *syncPods) reconcile(ctx context.Context, logger logr.Logger, instance *
 		}
 		return subResult{}
 	}
+
+	// The update is rolled out, so the next update starts from the first canary step
+	if instance.Status.CanaryStatus != nil {
+		instance.Status.CanaryStatus = nil
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to reset canary status")}
+		}
+	}
 	return subResult{}
 }
 
+// holdByAnalysis compares the metrics of the new EMQX nodes with the old EMQX nodes,
+// and returns true if the old EMQX nodes should not be scaled down because the new EMQX nodes regress.
+func (s *syncPods) holdByAnalysis(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface, role string, newNodes, oldNodes []string) (bool, error) {
+	analysis := instance.Spec.UpdateStrategy.Analysis
+	if analysis == nil {
+		return false, nil
+	}
+
+	metrics, err := getNodeMetricsByAPI(r)
+	if err != nil {
+		return false, err
+	}
+	alarms := map[string]int32{}
+	if analysis.MaxAlarms != nil {
+		if alarms, err = getActivatedAlarmsByAPI(r); err != nil {
+			return false, err
+		}
+	}
+	regressions, err := analyzeNodes(analysis, metrics, alarms, newNodes, oldNodes)
+	if err != nil {
+		return false, err
+	}
+
+	if len(regressions) == 0 {
+		if instance.Status.IsConditionTrue(appsv2beta1.AnalysisFailed) {
+			instance.Status.RemoveCondition(appsv2beta1.AnalysisFailed)
+			if err := s.Client.Status().Update(ctx, instance); err != nil {
+				return false, emperror.Wrap(err, "failed to update status")
+			}
+		}
+		return false, nil
+	}
+
+	message := fmt.Sprintf("The new %s nodes regress: %s", role, strings.Join(regressions, "; "))
+	if !instance.Status.IsConditionTrue(appsv2beta1.AnalysisFailed) {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.AnalysisFailed, message)
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.AnalysisFailed,
+			Status:  metav1.ConditionTrue,
+			Reason:  appsv2beta1.AnalysisFailed,
+			Message: message,
+		})
+		if err := s.Client.Status().Update(ctx, instance); err != nil {
+			return true, emperror.Wrap(err, "failed to update status")
+		}
+	}
+
+	if analysis.FailurePolicy == appsv2beta1.AnalysisFailurePolicyAbort {
+		if result := (&syncRollback{s.EMQXReconciler}).rollbackToCurrentRevision(ctx, logger, instance, r, role, appsv2beta1.AnalysisFailed, message); result.err != nil {
+			return true, result.err
+		}
+	}
+	return true, nil
+}
+
+// moveInPlacePartition moves the partition of the in-place updated statefulSet to the next ordinal,
+// when the pods from the current partition are updated and running in the EMQX cluster.
+func (s *syncPods) moveInPlacePartition(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, sts *appsv1.StatefulSet) subResult {
+	podList := &corev1.PodList{}
+	_ = s.Client.List(ctx, podList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(sts.Spec.Selector.MatchLabels),
+	)
+	if !checkInPlacePartitionReady(instance, sts, podList.Items) {
+		return subResult{}
+	}
+
+	partition := getStatefulSetPartition(sts) - 1
+	logger.Info("move partition of EMQX core nodes statefulSet", "statefulSet", klog.KObj(sts), "partition", partition)
+	sts.Spec.UpdateStrategy.RollingUpdate.Partition = ptr.To(partition)
+	if err := s.Client.Update(ctx, sts); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to move partition of statefulSet")}
+	}
+	s.EventRecorder.Event(instance, corev1.EventTypeNormal, "UpdateCoreNodeInPlace", fmt.Sprintf("Update core node %s-%d in place", sts.Name, partition))
+	return subResult{}
+}
+
+// holdByCanaryStep records the canary step in EMQX status, and returns true if the old EMQX nodes should not be scaled down yet.
+func (s *syncPods) holdByCanaryStep(ctx context.Context, instance *appsv2beta1.EMQX, revision string, desired, removed int32) (bool, error) {
+	if !isCanaryUpdate(instance) {
+		return false, nil
+	}
+
+	status, hold := nextCanaryStatus(instance, revision, desired, removed)
+	if reflect.DeepEqual(status, instance.Status.CanaryStatus) {
+		return hold, nil
+	}
+	if status.CurrentStepReachedTime != nil && (instance.Status.CanaryStatus == nil || instance.Status.CanaryStatus.CurrentStepReachedTime == nil) {
+		step := instance.Spec.UpdateStrategy.Canary.Steps[status.CurrentStepIndex]
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "CanaryStepReached", fmt.Sprintf("Canary step %d/%d reached, pause %d seconds", status.CurrentStepIndex+1, len(instance.Spec.UpdateStrategy.Canary.Steps), step.PauseSeconds))
+	}
+	instance.Status.CanaryStatus = status
+	if err := s.Client.Status().Update(ctx, instance); err != nil {
+		return false, err
+	}
+	return hold, nil
+}
+
 func (s *syncPods) canBeScaleDownRs(
 	ctx context.Context,
 	instance *appsv2beta1.EMQX,
@@ -172,6 +348,16 @@ func (s *syncPods) canBeScaleDownRs(
 		return nil, nil
 	}
 
+	if shouldDeletePodInfo.Edition != "Enterprise" && shouldDeletePodInfo.Session > 0 && instance.Spec.UpdateStrategy.EvacuationStrategy.OpenSourceDrain != nil {
+		drained, err := s.drainNode(ctx, instance, r, shouldDeletePod, shouldDeletePodInfo.Node)
+		if err != nil {
+			return nil, emperror.Wrap(err, "failed to drain node")
+		}
+		if !drained {
+			return nil, nil
+		}
+	}
+
 	// Open Source or Enterprise with no session
 	if !checkWaitTakeoverReady(instance, getEventList(ctx, s.Clientset, oldRs)) {
 		return nil, nil
@@ -242,6 +428,16 @@ func (s *syncPods) canBeScaleDownSts(
 		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "NodeEvacuation", fmt.Sprintf("Node %s is being evacuated", shouldDeletePodInfo.Node))
 		return false, nil
 	}
+
+	if shouldDeletePodInfo.Edition != "Enterprise" && shouldDeletePodInfo.Session > 0 && instance.Spec.UpdateStrategy.EvacuationStrategy.OpenSourceDrain != nil {
+		drained, err := s.drainNode(ctx, instance, r, shouldDeletePod, shouldDeletePodInfo.Node)
+		if err != nil {
+			return false, emperror.Wrap(err, "failed to drain node")
+		}
+		if !drained {
+			return false, nil
+		}
+	}
 	// Open Source or Enterprise with no session
 	if !checkWaitTakeoverReady(instance, getEventList(ctx, s.Clientset, oldSts)) {
 		return false, nil
@@ -249,6 +445,78 @@ func (s *syncPods) canBeScaleDownSts(
 	return true, nil
 }
 
+// drainNode kicks a batch of the clients of the EMQX open source node, at most ConnKickRate clients per second.
+// It returns true when there are no clients left on the node.
+func (s *syncPods) drainNode(ctx context.Context, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface, pod *corev1.Pod, nodeName string) (bool, error) {
+	lastDrainTime, isDraining := pod.Annotations[appsv2beta1.AnnotationsLastDrainTimeKey]
+	if isDraining {
+		if t, err := time.Parse(time.RFC3339Nano, lastDrainTime); err == nil && time.Since(t) < time.Second {
+			return false, nil
+		}
+	}
+
+	clientIDs, err := getClientIDsByAPI(r, nodeName, instance.Spec.UpdateStrategy.EvacuationStrategy.OpenSourceDrain.ConnKickRate)
+	if err != nil {
+		return false, err
+	}
+	if len(clientIDs) == 0 {
+		return true, nil
+	}
+	for _, clientID := range clientIDs {
+		if err := kickClientByAPI(r, clientID); err != nil {
+			return false, err
+		}
+	}
+
+	pod = pod.DeepCopy()
+	pod.Annotations = appsv2beta1.CloneAndAddLabel(pod.Annotations, appsv2beta1.AnnotationsLastDrainTimeKey, time.Now().Format(time.RFC3339Nano))
+	if err := s.Client.Update(ctx, pod); err != nil {
+		return false, emperror.Wrap(err, "failed to update pod")
+	}
+	if !isDraining {
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "NodeDraining", fmt.Sprintf("Node %s is being drained", nodeName))
+	}
+	return false, nil
+}
+
+func getClientIDsByAPI(r innerReq.RequesterInterface, nodeName string, limit int32) ([]string, error) {
+	if limit < 1 {
+		limit = 1
+	}
+	url := r.GetURL("api/v5/clients", "node="+nodeName, "limit="+strconv.Itoa(int(limit)))
+	resp, body, err := r.Request("GET", url, nil, nil)
+	if err != nil {
+		return nil, emperror.Wrap(err, "failed to get API api/v5/clients")
+	}
+	if resp.StatusCode != 200 {
+		return nil, emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+
+	clientIDs := []string{}
+	for _, data := range gjson.GetBytes(body, "data").Array() {
+		if clientID := data.Get("clientid").String(); clientID != "" {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	return clientIDs, nil
+}
+
+func kickClientByAPI(r innerReq.RequesterInterface, clientID string) error {
+	url := r.GetURL("api/v5/clients/" + neturl.PathEscape(clientID))
+	resp, body, err := r.Request("DELETE", url, nil, nil)
+	if err != nil {
+		return emperror.Wrap(err, "failed to request API api/v5/clients")
+	}
+	// The client has been disconnected already
+	if resp.StatusCode == 404 {
+		return nil
+	}
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		return emperror.Errorf("failed to request API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+	return nil
+}
+
 func getEMQXNodeInfoByAPI(r innerReq.RequesterInterface, nodeName string) (*appsv2beta1.EMQXNode, error) {
 	url := r.GetURL(fmt.Sprintf("api/v5/nodes/%s", nodeName))
 
@@ -304,3 +572,18 @@ func startEvacuationByAPI(r innerReq.RequesterInterface, instance *appsv2beta1.E
 	}
 	return nil
 }
+
+func stopEvacuationByAPI(r innerReq.RequesterInterface, nodeName string) error {
+	url := r.GetURL("api/v5/load_rebalance/" + nodeName + "/evacuation/stop")
+	resp, respBody, err := r.Request("POST", url, nil, nil)
+	if err != nil {
+		return emperror.Wrap(err, "failed to request API api/v5/load_rebalance/"+nodeName+"/evacuation/stop")
+	}
+	if resp.StatusCode == 400 && strings.Contains(string(respBody), "not_started") {
+		return nil
+	}
+	if resp.StatusCode != 200 {
+		return emperror.Errorf("failed to request API %s, status : %s, body: %s", url.String(), resp.Status, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package v2beta1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetClientIDsByAPI(t *testing.T) {
+	f := &innerReq.FakeRequester{}
+
+	t.Run("get client ids", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			assert.Equal(t, "GET", method)
+			assert.Equal(t, "api/v5/clients", url.Path)
+			return &http.Response{StatusCode: http.StatusOK}, []byte(`{"data":[{"clientid":"a"},{"clientid":"b"}],"meta":{"count":2}}`), nil
+		}
+		got, err := getClientIDsByAPI(f, "emqx@127.0.0.1", 100)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, got)
+	})
+
+	t.Run("no client", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			return &http.Response{StatusCode: http.StatusOK}, []byte(`{"data":[],"meta":{"count":0}}`), nil
+		}
+		got, err := getClientIDsByAPI(f, "emqx@127.0.0.1", 100)
+		assert.Nil(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("request failed", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}, nil, nil
+		}
+		_, err := getClientIDsByAPI(f, "emqx@127.0.0.1", 100)
+		assert.Error(t, err)
+	})
+}
+
+func TestKickClientByAPI(t *testing.T) {
+	f := &innerReq.FakeRequester{}
+
+	t.Run("kick client", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			assert.Equal(t, "DELETE", method)
+			assert.Equal(t, "api/v5/clients/client%2F1", url.Path)
+			return &http.Response{StatusCode: http.StatusNoContent}, nil, nil
+		}
+		assert.Nil(t, kickClientByAPI(f, "client/1"))
+	})
+
+	t.Run("client not found", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			return &http.Response{StatusCode: http.StatusNotFound}, nil, nil
+		}
+		assert.Nil(t, kickClientByAPI(f, "client"))
+	})
+
+	t.Run("request failed", func(t *testing.T) {
+		f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}, nil, nil
+		}
+		assert.Error(t, kickClientByAPI(f, "client"))
+	})
+}
+
+func TestSyncPodsPaused(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.UpdateStrategy.Paused = true
+	instance.Status.CoreNodesStatus.CurrentRevision = "current"
+	instance.Status.CoreNodesStatus.UpdateRevision = "update"
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Available, Status: metav1.ConditionTrue})
+	currentSts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx-core-current",
+			Namespace: "emqx",
+			Labels:    appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "current"),
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptr.To(int32(3))},
+	}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, currentSts).Build()
+	s := &syncPods{&EMQXReconciler{Handler: &handler.Handler{Client: k8sClient}}}
+	r := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			t.Fatalf("unexpected request %s %s while the update is paused", method, url.String())
+			return nil, nil, nil
+		},
+	}
+
+	// The old EMQX nodes are neither evacuated nor scaled down
+	result := s.reconcile(context.Background(), logr.Discard(), instance, r)
+	assert.Nil(t, result.err)
+	assert.True(t, result.result.IsZero())
+	got := &appsv1.StatefulSet{}
+	assert.Nil(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(currentSts), got))
+	assert.Equal(t, int32(3), *got.Spec.Replicas)
+}
+
+func TestSyncPodsResetCanaryStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Status.CoreNodesStatus.CurrentRevision = "current"
+	instance.Status.CoreNodesStatus.UpdateRevision = "current"
+	instance.Status.CanaryStatus = &appsv2beta1.CanaryStatus{Revision: "current", CurrentStepIndex: 1}
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Available, Status: metav1.ConditionTrue})
+	currentSts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx-core-current",
+			Namespace: "emqx",
+			Labels:    appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "current"),
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptr.To(int32(3))},
+	}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, currentSts).WithStatusSubresource(instance).Build()
+	s := &syncPods{&EMQXReconciler{Handler: &handler.Handler{Client: k8sClient}}}
+
+	// The update is rolled out, the next update starts from the first canary step
+	result := s.reconcile(context.Background(), logr.Discard(), instance, &innerReq.FakeRequester{})
+	assert.Nil(t, result.err)
+	assert.Nil(t, instance.Status.CanaryStatus)
+	got := &appsv2beta1.EMQX{}
+	assert.Nil(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(instance), got))
+	assert.Nil(t, got.Status.CanaryStatus)
+}
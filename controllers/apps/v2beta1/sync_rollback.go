@@ -0,0 +1,243 @@
+package v2beta1
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type syncRollback struct {
+	*EMQXReconciler
+}
+
+func (s *syncRollback) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface) subResult {
+	if instance.Spec.RollbackTo != nil {
+		return s.rollbackToRevision(ctx, logger, instance)
+	}
+
+	if err := s.updatePausedCondition(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+	if !checkProgressDeadlineExceeded(instance) {
+		return subResult{}
+	}
+
+	cond := instance.Status.GetLastTrueCondition()
+	switch cond.Type {
+	case appsv2beta1.CoreNodesProgressing:
+		message := fmt.Sprintf("Core nodes of revision %s are not ready in %d seconds, rollback to revision %s",
+			instance.Status.CoreNodesStatus.UpdateRevision, *instance.Spec.UpdateStrategy.ProgressDeadlineSeconds, instance.Status.CoreNodesStatus.CurrentRevision)
+		return s.rollbackToCurrentRevision(ctx, logger, instance, r, "core", "ProgressDeadlineExceeded", message)
+	case appsv2beta1.ReplicantNodesProgressing:
+		message := fmt.Sprintf("Replicant nodes of revision %s are not ready in %d seconds, rollback to revision %s",
+			instance.Status.ReplicantNodesStatus.UpdateRevision, *instance.Spec.UpdateStrategy.ProgressDeadlineSeconds, instance.Status.ReplicantNodesStatus.CurrentRevision)
+		return s.rollbackToCurrentRevision(ctx, logger, instance, r, "replicant", "ProgressDeadlineExceeded", message)
+	}
+	return subResult{}
+}
+
+// rollbackToCurrentRevision restores the EMQX spec from the current statefulSet or replicaSet of the role,
+// scales down the update statefulSet or replicaSet, and sets the RollbackTriggered condition.
+func (s *syncRollback) rollbackToCurrentRevision(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface, role, reason, message string) subResult {
+	var current, update client.Object
+	switch role {
+	case "core":
+		if instance.Status.CoreNodesStatus.UpdateRevision == instance.Status.CoreNodesStatus.CurrentRevision {
+			return subResult{}
+		}
+		updateSts, currentSts, _ := getStateFulSetList(ctx, s.Client, instance)
+		if currentSts == nil {
+			return subResult{}
+		}
+		current = currentSts
+		if updateSts != nil && updateSts.UID != currentSts.UID {
+			updateSts.Spec.Replicas = ptr.To(int32(0))
+			update = updateSts
+		}
+	case "replicant":
+		if instance.Status.ReplicantNodesStatus.UpdateRevision == instance.Status.ReplicantNodesStatus.CurrentRevision {
+			return subResult{}
+		}
+		updateRs, currentRs, _ := getReplicaSetList(ctx, s.Client, instance)
+		if currentRs == nil {
+			return subResult{}
+		}
+		current = currentRs
+		if updateRs != nil {
+			updateRs.Spec.Replicas = ptr.To(int32(0))
+			update = updateRs
+		}
+	default:
+		return subResult{}
+	}
+
+	if err := restoreRevisionSpec(instance, current); err != nil {
+		// The sets created before the revision spec annotation was introduced can not be rolled back to,
+		// the update is left as it is instead of failing every reconcile
+		return s.skipRollback(ctx, instance, fmt.Sprintf("%s, but the rollback is skipped: %s", message, err.Error()))
+	}
+
+	if r != nil {
+		for _, evacuation := range instance.Status.NodeEvacuationsStatus {
+			if err := stopEvacuationByAPI(r, evacuation.Node); err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to stop node evacuation")}
+			}
+		}
+	}
+
+	if err := s.Client.Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to rollback EMQX spec")}
+	}
+	// The new EMQX nodes are not wanted any more, so scale them down immediately.
+	if update != nil {
+		if err := s.Client.Update(ctx, update); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to scale down failed revision")}
+		}
+	}
+
+	logger.Info("rollback EMQX spec", "reason", reason, "message", message)
+	s.EventRecorder.Event(instance, corev1.EventTypeWarning, appsv2beta1.RollbackTriggered, message)
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_ = s.Client.Get(ctx, client.ObjectKeyFromObject(instance), instance)
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.RollbackTriggered,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: message,
+		})
+		// The canary steps start over when the update is retried
+		instance.Status.CanaryStatus = nil
+		return s.Client.Status().Update(ctx, instance)
+	}); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+	return subResult{}
+}
+
+// updatePausedCondition sets the Paused condition when .spec.updateStrategy.paused is set,
+// and sets it to false when the update is resumed, so the progress deadline restarts from then.
+func (s *syncRollback) updatePausedCondition(ctx context.Context, instance *appsv2beta1.EMQX) error {
+	paused := instance.Spec.UpdateStrategy.Paused
+	if paused == instance.Status.IsConditionTrue(appsv2beta1.Paused) {
+		return nil
+	}
+	_, condition := instance.Status.GetCondition(appsv2beta1.Paused)
+	if !paused && condition == nil {
+		return nil
+	}
+
+	if paused {
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "Paused", "The update of the EMQX nodes is paused")
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.Paused,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Paused",
+			Message: "The update of the EMQX nodes is paused by .spec.updateStrategy.paused",
+		})
+	} else {
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "Resumed", "The update of the EMQX nodes is resumed")
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.Paused,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Resumed",
+			Message: "The update of the EMQX nodes is resumed",
+		})
+	}
+	return s.Client.Status().Update(ctx, instance)
+}
+
+// skipRollback records that the rollback is not possible in the RollbackTriggered condition with the false status,
+// the event is emitted only once for the same message.
+func (s *syncRollback) skipRollback(ctx context.Context, instance *appsv2beta1.EMQX, message string) subResult {
+	if _, condition := instance.Status.GetCondition(appsv2beta1.RollbackTriggered); condition != nil && condition.Reason == "RollbackFailed" && condition.Message == message {
+		return subResult{}
+	}
+	s.EventRecorder.Event(instance, corev1.EventTypeWarning, "RollbackFailed", message)
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_ = s.Client.Get(ctx, client.ObjectKeyFromObject(instance), instance)
+		instance.Status.SetCondition(metav1.Condition{
+			Type:    appsv2beta1.RollbackTriggered,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RollbackFailed",
+			Message: message,
+		})
+		return s.Client.Status().Update(ctx, instance)
+	}); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update status")}
+	}
+	return subResult{}
+}
+
+// rollbackToRevision restores the EMQX spec from the retained statefulSet or replicaSet of the requested revision,
+// then addCore and addRepl will scale the retained sets up again, and syncPods will scale the current sets down.
+func (s *syncRollback) rollbackToRevision(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX) subResult {
+	revision := instance.Spec.RollbackTo.Revision
+
+	var target client.Object
+	stsList := &appsv1.StatefulSetList{}
+	_ = s.Client.List(ctx, stsList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, revision)),
+	)
+	if len(stsList.Items) > 0 {
+		target = stsList.Items[0].DeepCopy()
+	} else {
+		rsList := &appsv1.ReplicaSetList{}
+		_ = s.Client.List(ctx, rsList,
+			client.InNamespace(instance.Namespace),
+			client.MatchingLabels(appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultReplicantLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, revision)),
+		)
+		if len(rsList.Items) > 0 {
+			target = rsList.Items[0].DeepCopy()
+		}
+	}
+
+	// Always clear the rollbackTo field, so that a wrong revision will not block the reconcile loop.
+	instance.Spec.RollbackTo = nil
+	restored := false
+	if target == nil {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, "RollbackRevisionNotFound", fmt.Sprintf("Revision %s is not retained, skip rollback", revision))
+	} else if err := restoreRevisionSpec(instance, target); err != nil {
+		s.EventRecorder.Event(instance, corev1.EventTypeWarning, "RollbackFailed", err.Error())
+	} else {
+		restored = true
+	}
+	if err := s.Client.Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to rollback EMQX spec")}
+	}
+	if restored {
+		logger.Info("rollback EMQX spec", "revision", revision)
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "RollbackToRevision", fmt.Sprintf("Rollback to revision %s", revision))
+	}
+	return subResult{result: ctrl.Result{Requeue: true}}
+}
+
+// checkProgressDeadlineExceeded returns true if the EMQX nodes have been progressing longer than the progress deadline,
+// the progress deadline is suspended while the update is paused, and restarts when the update is resumed.
+func checkProgressDeadlineExceeded(instance *appsv2beta1.EMQX) bool {
+	if instance.Spec.UpdateStrategy.ProgressDeadlineSeconds == nil || instance.Spec.UpdateStrategy.Paused {
+		return false
+	}
+	cond := instance.Status.GetLastTrueCondition()
+	if cond == nil || (cond.Type != appsv2beta1.CoreNodesProgressing && cond.Type != appsv2beta1.ReplicantNodesProgressing) {
+		return false
+	}
+	start := cond.LastTransitionTime
+	if _, paused := instance.Status.GetCondition(appsv2beta1.Paused); paused != nil && paused.Status == metav1.ConditionFalse && start.Before(&paused.LastTransitionTime) {
+		start = paused.LastTransitionTime
+	}
+	delay := time.Since(start.Time).Seconds()
+	return int32(delay) > *instance.Spec.UpdateStrategy.ProgressDeadlineSeconds
+}
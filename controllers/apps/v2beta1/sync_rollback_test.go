@@ -0,0 +1,151 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckProgressDeadlineExceeded(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	instance.Status.Conditions = []metav1.Condition{
+		{
+			Type:               appsv2beta1.CoreNodesProgressing,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+		},
+	}
+	assert.False(t, checkProgressDeadlineExceeded(instance))
+
+	instance.Spec.UpdateStrategy.ProgressDeadlineSeconds = ptr.To(int32(30))
+	assert.True(t, checkProgressDeadlineExceeded(instance))
+
+	// The deadline is suspended while the update is paused
+	instance.Spec.UpdateStrategy.Paused = true
+	assert.False(t, checkProgressDeadlineExceeded(instance))
+
+	// The deadline restarts when the update is resumed
+	instance.Spec.UpdateStrategy.Paused = false
+	instance.Status.Conditions = append(instance.Status.Conditions, metav1.Condition{
+		Type:               appsv2beta1.Paused,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Second)),
+	})
+	assert.False(t, checkProgressDeadlineExceeded(instance))
+	instance.Status.Conditions[1].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Minute))
+	assert.True(t, checkProgressDeadlineExceeded(instance))
+}
+
+func TestUpdatePausedCondition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	recorder := record.NewFakeRecorder(10)
+	s := &syncRollback{
+		EMQXReconciler: &EMQXReconciler{
+			Handler: &handler.Handler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build(),
+			},
+			EventRecorder: recorder,
+		},
+	}
+	ctx := context.Background()
+
+	// Nothing is recorded if the update was never paused
+	assert.Nil(t, s.updatePausedCondition(ctx, instance))
+	_, condition := instance.Status.GetCondition(appsv2beta1.Paused)
+	assert.Nil(t, condition)
+	assert.Len(t, recorder.Events, 0)
+
+	instance.Spec.UpdateStrategy.Paused = true
+	assert.Nil(t, s.Client.Update(ctx, instance))
+	assert.Nil(t, s.updatePausedCondition(ctx, instance))
+	assert.Nil(t, s.updatePausedCondition(ctx, instance))
+	assert.True(t, instance.Status.IsConditionTrue(appsv2beta1.Paused))
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Paused")
+
+	instance.Spec.UpdateStrategy.Paused = false
+	assert.Nil(t, s.Client.Update(ctx, instance))
+	assert.Nil(t, s.updatePausedCondition(ctx, instance))
+	assert.Nil(t, s.updatePausedCondition(ctx, instance))
+	_, condition = instance.Status.GetCondition(appsv2beta1.Paused)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "Resumed", condition.Reason)
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Resumed")
+}
+
+func TestRollbackToCurrentRevisionWithoutRevisionSpec(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+		Spec:       appsv2beta1.EMQXSpec{Image: "emqx/emqx:5.8.1"},
+	}
+	instance.Status.CoreNodesStatus.CurrentRevision = "current"
+	instance.Status.CoreNodesStatus.UpdateRevision = "update"
+
+	// The current statefulSet was created before the revision spec annotation was introduced
+	currentSts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx-core-current",
+			Namespace: "emqx",
+			Labels:    appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "current"),
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptr.To(int32(1))},
+	}
+	updateSts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx-core-update",
+			Namespace: "emqx",
+			Labels:    appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "update"),
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptr.To(int32(1))},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	s := &syncRollback{
+		EMQXReconciler: &EMQXReconciler{
+			Handler: &handler.Handler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, currentSts, updateSts).WithStatusSubresource(instance).Build(),
+			},
+			EventRecorder: recorder,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		result := s.rollbackToCurrentRevision(context.Background(), logr.Discard(), instance, nil, "core", "ProgressDeadlineExceeded", "Core nodes are not ready")
+		assert.Nil(t, result.err)
+	}
+
+	// The event is emitted only once
+	assert.Len(t, recorder.Events, 1)
+	_, condition := instance.Status.GetCondition(appsv2beta1.RollbackTriggered)
+	assert.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "RollbackFailed", condition.Reason)
+
+	// Neither the EMQX spec nor the update statefulSet is changed
+	assert.Equal(t, "emqx/emqx:5.8.1", instance.Spec.Image)
+	got := &appsv1.StatefulSet{}
+	assert.Nil(t, s.Client.Get(context.Background(), client.ObjectKeyFromObject(updateSts), got))
+	assert.Equal(t, int32(1), *got.Spec.Replicas)
+}
@@ -2,10 +2,13 @@ package v2beta1
 
 import (
 	"context"
+	"strconv"
+	"strings"
 
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog/v2"
@@ -63,6 +66,10 @@ func (s *syncSets) reconcile(ctx context.Context, logger logr.Logger, instance *
 				if pvc.DeletionTimestamp != nil {
 					continue
 				}
+				// The selector of the in-place updated statefulSet has no pod template hash, and matches the PVCs of the other statefulSets too
+				if _, ok := sts.Spec.Selector.MatchLabels[appsv2beta1.LabelsPodTemplateHashKey]; !ok && !isPVCOfStatefulSet(pvc, sts) {
+					continue
+				}
 				logger.Info("trying to cleanup persistentVolumeClaim for EMQX", "persistentVolumeClaim", klog.KObj(pvc), "EMQX", klog.KObj(instance))
 				if err := s.Client.Delete(ctx, pvc); err != nil && !k8sErrors.IsNotFound(err) {
 					return subResult{err: err}
@@ -73,3 +80,17 @@ func (s *syncSets) reconcile(ctx context.Context, logger logr.Logger, instance *
 
 	return subResult{}
 }
+
+// isPVCOfStatefulSet checks whether the PVC is created from the volume claim templates of the statefulSet by the PVC name.
+func isPVCOfStatefulSet(pvc *corev1.PersistentVolumeClaim, sts *appsv1.StatefulSet) bool {
+	for _, vct := range sts.Spec.VolumeClaimTemplates {
+		ordinal, found := strings.CutPrefix(pvc.Name, vct.Name+"-"+sts.Name+"-")
+		if !found {
+			continue
+		}
+		if _, err := strconv.Atoi(ordinal); err == nil {
+			return true
+		}
+	}
+	return false
+}
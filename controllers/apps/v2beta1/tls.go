@@ -0,0 +1,312 @@
+package v2beta1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	emperror "emperror.dev/errors"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tlsMountPath = "/mounted/tls"
+
+// The kubelet syncs the mounted secrets periodically, so wait for the renewed certificates are synced to the EMQX nodes before reloading the listeners
+const tlsReloadDelay = 90 * time.Second
+
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+type syncTLS struct {
+	*EMQXReconciler
+}
+
+func (s *syncTLS) reconcile(ctx context.Context, logger logr.Logger, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface) subResult {
+	if len(instance.Spec.TLS) == 0 {
+		if _, ok := instance.Annotations[appsv2beta1.AnnotationsTLSCertificatesHashKey]; !ok {
+			return subResult{}
+		}
+		// Delete the certificates generated before .spec.tls was removed
+		if err := s.pruneCertificates(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to prune cert-manager certificates")}
+		}
+		delete(instance.Annotations, appsv2beta1.AnnotationsTLSCertificatesHashKey)
+		delete(instance.Annotations, appsv2beta1.AnnotationsTLSCertificatesChangedAtKey)
+		if err := s.Client.Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
+		}
+		return subResult{}
+	}
+
+	resources := []client.Object{}
+	for _, tls := range instance.Spec.TLS {
+		resources = append(resources, generateCertificate(instance, tls))
+	}
+	if err := s.CreateOrUpdateList(ctx, s.Scheme, logger, instance, resources); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to create or update cert-manager certificates")}
+	}
+	if err := s.pruneCertificates(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to prune cert-manager certificates")}
+	}
+
+	if r == nil || !instance.Status.IsConditionTrue(appsv2beta1.CoreNodesReady) {
+		return subResult{}
+	}
+
+	hash, err := s.computeTLSSecretsHash(ctx, instance)
+	if err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to compute the hash of the TLS secrets")}
+	}
+	lastHash, ok := instance.Annotations[appsv2beta1.AnnotationsTLSCertificatesHashKey]
+	if ok && lastHash == hash {
+		return subResult{}
+	}
+	if ok {
+		// The certificates are renewed, reload the listeners after the secrets are synced to the EMQX nodes
+		changedAt, err := time.Parse(time.RFC3339, instance.Annotations[appsv2beta1.AnnotationsTLSCertificatesChangedAtKey])
+		if err != nil {
+			instance.Annotations[appsv2beta1.AnnotationsTLSCertificatesChangedAtKey] = time.Now().Format(time.RFC3339)
+			if err := s.Client.Update(ctx, instance); err != nil {
+				return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
+			}
+			return subResult{}
+		}
+		if time.Since(changedAt) < tlsReloadDelay {
+			return subResult{}
+		}
+
+		listeners := getTLSListeners(instance)
+		for _, listener := range listeners {
+			if err := reloadListenerByAPI(r, listener); err != nil {
+				return subResult{err: emperror.Wrapf(err, "failed to reload listener %s", listener)}
+			}
+		}
+		s.EventRecorder.Event(instance, corev1.EventTypeNormal, "TLSCertificatesReloaded", fmt.Sprintf("Reloaded the listeners with the renewed TLS certificates: %s", strings.Join(listeners, ", ")))
+	}
+
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+	instance.Annotations[appsv2beta1.AnnotationsTLSCertificatesHashKey] = hash
+	delete(instance.Annotations, appsv2beta1.AnnotationsTLSCertificatesChangedAtKey)
+	if err := s.Client.Update(ctx, instance); err != nil {
+		return subResult{err: emperror.Wrap(err, "failed to update emqx instance annotation")}
+	}
+	return subResult{}
+}
+
+// pruneCertificates deletes the certificates of the removed or renamed .spec.tls entries
+func (s *syncTLS) pruneCertificates(ctx context.Context, instance *appsv2beta1.EMQX) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	if err := s.Client.List(ctx, list,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultLabels(instance)),
+		client.HasLabels{appsv2beta1.LabelsTLSCertificateKey},
+	); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return emperror.Wrap(err, "failed to list certificates")
+	}
+
+	names := []string{}
+	for _, tls := range instance.Spec.TLS {
+		names = append(names, instance.TLSCertificateNamespacedName(tls.Name).Name)
+	}
+	for i := range list.Items {
+		certificate := &list.Items[i]
+		if !metav1.IsControlledBy(certificate, instance) || slices.Contains(names, certificate.GetName()) {
+			continue
+		}
+		if err := s.Client.Delete(ctx, certificate); err != nil && !k8sErrors.IsNotFound(err) {
+			return emperror.Wrapf(err, "failed to delete certificate %s", certificate.GetName())
+		}
+	}
+	return nil
+}
+
+// computeTLSSecretsHash returns the hash of the TLS secrets and the client CA secrets, the secrets not found are skipped.
+func (s *syncTLS) computeTLSSecretsHash(ctx context.Context, instance *appsv2beta1.EMQX) (string, error) {
+	data := []string{}
+	for _, name := range getTLSSecretNames(instance) {
+		secret := &corev1.Secret{}
+		if err := s.Client.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+			if k8sErrors.IsNotFound(err) {
+				continue
+			}
+			return "", emperror.Wrapf(err, "failed to get secret %s", name)
+		}
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			data = append(data, fmt.Sprintf("%s/%s=%s", name, key, secret.Data[key]))
+		}
+	}
+	return computeStringHash(strings.Join(data, "\n")), nil
+}
+
+func generateCertificate(instance *appsv2beta1.EMQX, tls appsv2beta1.TLSCertificate) *unstructured.Unstructured {
+	dnsNames := tls.DNSNames
+	if len(dnsNames) == 0 {
+		svc := instance.ListenersServiceNamespacedName()
+		dnsNames = []string{
+			svc.Name,
+			fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace),
+			fmt.Sprintf("%s.%s.svc.%s", svc.Name, svc.Namespace, instance.Spec.ClusterDomain),
+		}
+	}
+	dnsNamesValue := make([]interface{}, 0, len(dnsNames))
+	for _, dnsName := range dnsNames {
+		dnsNamesValue = append(dnsNamesValue, dnsName)
+	}
+
+	name := instance.TLSCertificateNamespacedName(tls.Name)
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetNamespace(name.Namespace)
+	certificate.SetName(name.Name)
+	certificate.SetLabels(appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultLabels(instance), appsv2beta1.LabelsTLSCertificateKey, tls.Name))
+	certificate.Object["spec"] = map[string]interface{}{
+		"secretName": name.Name,
+		"dnsNames":   dnsNamesValue,
+		"issuerRef": map[string]interface{}{
+			"name":  tls.IssuerRef.Name,
+			"kind":  tls.IssuerRef.Kind,
+			"group": tls.IssuerRef.Group,
+		},
+	}
+	return certificate
+}
+
+// getTLSSecretNames returns the names of the TLS secrets and the client CA secrets in .spec.tls
+func getTLSSecretNames(instance *appsv2beta1.EMQX) []string {
+	names := []string{}
+	for _, tls := range instance.Spec.TLS {
+		names = append(names, instance.TLSCertificateNamespacedName(tls.Name).Name)
+		if tls.ClientCASecretRef != nil {
+			names = append(names, tls.ClientCASecretRef.Name)
+		}
+	}
+	return names
+}
+
+// getTLSListeners returns the sorted listeners that use the TLS certificates in .spec.tls
+func getTLSListeners(instance *appsv2beta1.EMQX) []string {
+	listeners := []string{}
+	for _, tls := range instance.Spec.TLS {
+		listeners = append(listeners, tls.Listeners...)
+	}
+	sort.Strings(listeners)
+	return listeners
+}
+
+// mergeTLSConfig sets the ssl_options of the listeners that use the TLS certificates in .spec.tls
+func mergeTLSConfig(instance *appsv2beta1.EMQX, config string) string {
+	if len(instance.Spec.TLS) == 0 {
+		return config
+	}
+	tlsConfig := ""
+	for _, tls := range instance.Spec.TLS {
+		for _, listener := range tls.Listeners {
+			prefix := fmt.Sprintf("listeners.%s.ssl_options", strings.Replace(listener, "-", ".", 1))
+			tlsConfig += fmt.Sprintf("%s.certfile = \"%s/%s/%s\"\n", prefix, tlsMountPath, tls.Name, corev1.TLSCertKey)
+			tlsConfig += fmt.Sprintf("%s.keyfile = \"%s/%s/%s\"\n", prefix, tlsMountPath, tls.Name, corev1.TLSPrivateKeyKey)
+			if tls.ClientCASecretRef != nil {
+				tlsConfig += fmt.Sprintf("%s.cacertfile = \"%s/%s-client-ca/%s\"\n", prefix, tlsMountPath, tls.Name, corev1.ServiceAccountRootCAKey)
+				tlsConfig += fmt.Sprintf("%s.verify = verify_peer\n", prefix)
+				tlsConfig += fmt.Sprintf("%s.fail_if_no_peer_cert = true\n", prefix)
+			}
+		}
+	}
+	return fmt.Sprintf("%s\n%s", config, tlsConfig)
+}
+
+// addTLSVolumes mounts the TLS secrets and the client CA secrets in .spec.tls to the EMQX container
+func addTLSVolumes(instance *appsv2beta1.EMQX, podSpec *corev1.PodSpec) {
+	for _, tls := range instance.Spec.TLS {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "tls-" + tls.Name,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: instance.TLSCertificateNamespacedName(tls.Name).Name,
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "tls-" + tls.Name,
+			MountPath: fmt.Sprintf("%s/%s", tlsMountPath, tls.Name),
+			ReadOnly:  true,
+		})
+		if tls.ClientCASecretRef != nil {
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: "tls-" + tls.Name + "-client-ca",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: tls.ClientCASecretRef.Name,
+					},
+				},
+			})
+			podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+				Name:      "tls-" + tls.Name + "-client-ca",
+				MountPath: fmt.Sprintf("%s/%s-client-ca", tlsMountPath, tls.Name),
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// reloadListenerByAPI updates the listener with its current config, so EMQX reloads the certificates of the listener
+// without closing the connections.
+func reloadListenerByAPI(r innerReq.RequesterInterface, listener string) error {
+	url := r.GetURL(fmt.Sprintf("api/v5/listeners/%s", strings.Replace(listener, "-", ":", 1)))
+
+	resp, body, err := r.Request("GET", url, nil, nil)
+	if err != nil {
+		return emperror.Wrapf(err, "failed to get API %s", url.String())
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != 200 {
+		return emperror.Errorf("failed to get API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+
+	listenerConfig := map[string]interface{}{}
+	if err := json.Unmarshal(body, &listenerConfig); err != nil {
+		return emperror.Wrap(err, "failed to unmarshal listener")
+	}
+	// The runtime status is not the config of the listener
+	delete(listenerConfig, "status")
+	delete(listenerConfig, "node_status")
+	b, err := json.Marshal(listenerConfig)
+	if err != nil {
+		return emperror.Wrap(err, "failed to marshal listener")
+	}
+
+	resp, body, err = r.Request("PUT", url, b, nil)
+	if err != nil {
+		return emperror.Wrapf(err, "failed to put API %s", url.String())
+	}
+	if resp.StatusCode != 200 {
+		return emperror.Errorf("failed to put API %s, status : %s, body: %s", url.String(), resp.Status, body)
+	}
+	return nil
+}
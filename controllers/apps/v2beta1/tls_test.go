@@ -0,0 +1,143 @@
+package v2beta1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	innerReq "github.com/emqx/emqx-operator/internal/requester"
+	"github.com/rory-z/go-hocon"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGenerateCertificate(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	instance.Spec.ClusterDomain = "cluster.local"
+	tls := appsv2beta1.TLSCertificate{
+		Name:      "mqtts",
+		IssuerRef: appsv2beta1.TLSIssuerReference{Name: "ca", Kind: "ClusterIssuer", Group: "cert-manager.io"},
+	}
+
+	got := generateCertificate(instance, tls)
+	assert.Equal(t, "cert-manager.io/v1", got.GetAPIVersion())
+	assert.Equal(t, "Certificate", got.GetKind())
+	assert.Equal(t, "emqx-mqtts-tls", got.GetName())
+	assert.Equal(t, map[string]interface{}{
+		"secretName": "emqx-mqtts-tls",
+		"dnsNames":   []interface{}{"emqx-listeners", "emqx-listeners.emqx.svc", "emqx-listeners.emqx.svc.cluster.local"},
+		"issuerRef": map[string]interface{}{
+			"name":  "ca",
+			"kind":  "ClusterIssuer",
+			"group": "cert-manager.io",
+		},
+	}, got.Object["spec"])
+
+	tls.DNSNames = []string{"mqtt.example.com"}
+	got = generateCertificate(instance, tls)
+	assert.Equal(t, []interface{}{"mqtt.example.com"}, got.Object["spec"].(map[string]interface{})["dnsNames"])
+	assert.Equal(t, "mqtts", got.GetLabels()[appsv2beta1.LabelsTLSCertificateKey])
+}
+
+func TestPruneCertificates(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx", UID: "fake-uid"},
+	}
+	instance.Spec.TLS = []appsv2beta1.TLSCertificate{{Name: "mqtts"}}
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+
+	current := generateCertificate(instance, appsv2beta1.TLSCertificate{Name: "mqtts"})
+	stale := generateCertificate(instance, appsv2beta1.TLSCertificate{Name: "wss"})
+	for _, certificate := range []*unstructured.Unstructured{current, stale} {
+		assert.Nil(t, ctrl.SetControllerReference(instance, certificate, scheme))
+	}
+	// The certificates not controlled by the EMQX are kept
+	other := generateCertificate(instance, appsv2beta1.TLSCertificate{Name: "other"})
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, current, stale, other).Build()
+	s := &syncTLS{&EMQXReconciler{
+		Handler: &handler.Handler{Client: k8sClient},
+		Scheme:  scheme,
+	}}
+	assert.Nil(t, s.pruneCertificates(context.Background(), instance))
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind("CertificateList"))
+	assert.Nil(t, k8sClient.List(context.Background(), list))
+	names := []string{}
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	assert.ElementsMatch(t, []string{"emqx-mqtts-tls", "emqx-other-tls"}, names)
+}
+
+func TestMergeTLSConfig(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+	}
+	assert.Equal(t, "a = 1", mergeTLSConfig(instance, "a = 1"))
+
+	instance.Spec.TLS = []appsv2beta1.TLSCertificate{
+		{Name: "mqtts", Listeners: []string{"ssl-default", "wss-default"}},
+		{Name: "internal", Listeners: []string{"ssl-internal"}, ClientCASecretRef: &corev1.LocalObjectReference{Name: "client-ca"}},
+	}
+	hoconConfig, err := hocon.ParseString(mergeTLSConfig(instance, "listeners.ssl.default.bind = 8883"))
+	assert.Nil(t, err)
+	assert.Equal(t, "8883", hoconConfig.GetString("listeners.ssl.default.bind"))
+	assert.Equal(t, `"/mounted/tls/mqtts/tls.crt"`, hoconConfig.GetString("listeners.ssl.default.ssl_options.certfile"))
+	assert.Equal(t, `"/mounted/tls/mqtts/tls.key"`, hoconConfig.GetString("listeners.wss.default.ssl_options.keyfile"))
+	assert.Equal(t, `"/mounted/tls/internal-client-ca/ca.crt"`, hoconConfig.GetString("listeners.ssl.internal.ssl_options.cacertfile"))
+	assert.Equal(t, `"verify_peer"`, hoconConfig.GetString("listeners.ssl.internal.ssl_options.verify"))
+	assert.Empty(t, hoconConfig.GetString("listeners.ssl.default.ssl_options.verify"))
+
+	assert.Equal(t, []string{"emqx-mqtts-tls", "emqx-internal-tls", "client-ca"}, getTLSSecretNames(instance))
+	assert.Equal(t, []string{"ssl-default", "ssl-internal", "wss-default"}, getTLSListeners(instance))
+
+	instance.Spec.ReplicantTemplate = &appsv2beta1.EMQXReplicantTemplate{}
+	instance.Spec.ReplicantTemplate.Spec.Replicas = ptr.To(int32(1))
+	rs := getNewReplicaSet(instance)
+	assert.Contains(t, rs.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: "tls-internal-client-ca",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: "client-ca"},
+		},
+	})
+	assert.Contains(t, rs.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "tls-mqtts",
+		MountPath: "/mounted/tls/mqtts",
+		ReadOnly:  true,
+	})
+}
+
+func TestReloadListenerByAPI(t *testing.T) {
+	var putBody []byte
+	f := &innerReq.FakeRequester{
+		ReqFunc: func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+			assert.Equal(t, "api/v5/listeners/ssl:default", url.Path)
+			if method == "GET" {
+				return &http.Response{StatusCode: http.StatusOK}, []byte(`{"id":"ssl:default","bind":"0.0.0.0:8883","status":{"running":true}}`), nil
+			}
+			putBody = body
+			return &http.Response{StatusCode: http.StatusOK}, nil, nil
+		},
+	}
+	assert.Nil(t, reloadListenerByAPI(f, "ssl-default"))
+	assert.JSONEq(t, `{"id":"ssl:default","bind":"0.0.0.0:8883"}`, string(putBody))
+
+	f.ReqFunc = func(method string, url url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Status: "500"}, nil, nil
+	}
+	assert.Error(t, reloadListenerByAPI(f, "ssl-default"))
+}
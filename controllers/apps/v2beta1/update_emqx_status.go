@@ -11,6 +11,7 @@ import (
 	innerReq "github.com/emqx/emqx-operator/internal/requester"
 	"github.com/go-logr/logr"
 	"github.com/tidwall/gjson"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -54,6 +55,17 @@ func (u *updateStatus) reconcile(ctx context.Context, logger logr.Logger, instan
 		}
 	}
 
+	if updateSts != nil && currentSts != nil && updateSts.UID == currentSts.UID &&
+		instance.Status.CoreNodesStatus.CurrentRevision != instance.Status.CoreNodesStatus.UpdateRevision &&
+		checkInPlaceUpdateCompleted(updateSts) && u.checkInPlacePodsRunning(ctx, instance, updateSts) {
+		// All the pods of the in-place updated statefulSet are updated and running in the EMQX cluster
+		instance.Status.CoreNodesStatus.CurrentRevision = instance.Status.CoreNodesStatus.UpdateRevision
+		if err := u.Client.Status().Update(ctx, instance); err != nil {
+			return subResult{err: emperror.Wrap(err, "failed to update status")}
+		}
+		return subResult{}
+	}
+
 	updateRs, currentRs, oldRsList := getReplicaSetList(ctx, u.Client, instance)
 	if updateRs != nil {
 		if currentRs == nil || (updateRs.UID != currentRs.UID && currentRs.Status.Replicas == 0) {
@@ -75,6 +87,8 @@ func (u *updateStatus) reconcile(ctx context.Context, logger logr.Logger, instan
 		}
 	}
 
+	instance.Status.Revisions = getRevisions(ctx, u.Client, instance)
+
 	if r == nil {
 		return subResult{}
 	}
@@ -141,6 +155,17 @@ func (u *updateStatus) reconcile(ctx context.Context, logger logr.Logger, instan
 	return subResult{}
 }
 
+// checkInPlacePodsRunning returns true if all the EMQX core nodes of the in-place updated statefulSet are running,
+// so the last updated EMQX core node has rejoined the EMQX cluster before the update is completed.
+func (u *updateStatus) checkInPlacePodsRunning(ctx context.Context, instance *appsv2beta1.EMQX, sts *appsv1.StatefulSet) bool {
+	podList := &corev1.PodList{}
+	_ = u.Client.List(ctx, podList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(sts.Spec.Selector.MatchLabels),
+	)
+	return checkInPlacePartitionReady(instance, sts, podList.Items)
+}
+
 func (u *updateStatus) getEMQXNodes(ctx context.Context, instance *appsv2beta1.EMQX, r innerReq.RequesterInterface) (coreNodes, replicantNodes []appsv2beta1.EMQXNode, err error) {
 	emqxNodes, err := getEMQXNodesByAPI(r)
 	if err != nil {
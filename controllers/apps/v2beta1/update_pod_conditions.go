@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"net"
-	"strconv"
 
 	semver "github.com/Masterminds/semver/v3"
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
@@ -73,6 +72,13 @@ func (u *updatePodConditions) reconcile(ctx context.Context, logger logr.Logger,
 				}
 			}
 		case currentStsUID, currentRsUID:
+			// In rolling update and canary update, current pods keep serving until they are evacuated one by one
+			if controllerRef.UID == currentRsUID && (isRollingUpdate(instance) || isCanaryUpdate(instance)) {
+				break
+			}
+			if controllerRef.UID == currentStsUID && isCanaryUpdate(instance) && !appsv2beta1.IsExistReplicant(instance) {
+				break
+			}
 			// When available condition is true, need clean currentSts / currentRs pod
 			if instance.Status.IsConditionTrue(appsv2beta1.Available) {
 				for _, condition := range pod.Status.Conditions {
@@ -122,17 +128,7 @@ func (u *updatePodConditions) checkRebalanceStatus(instance *appsv2beta1.EMQX, r
 		return corev1.ConditionFalse
 	}
 
-	portMap, _ := appsv2beta1.GetDashboardPortMap(instance.Spec.Config.Data)
-
-	var schema, port string
-	if dashboardHttps, ok := portMap["dashboard-https"]; ok {
-		schema = "https"
-		port = strconv.FormatInt(int64(dashboardHttps), 10)
-	}
-	if dashboard, ok := portMap["dashboard"]; ok {
-		schema = "http"
-		port = strconv.FormatInt(int64(dashboard), 10)
-	}
+	schema, port, _ := getManagementAPISchemaAndPort(instance)
 
 	requester := &innerReq.Requester{
 		Schema:   schema,
@@ -140,6 +136,9 @@ func (u *updatePodConditions) checkRebalanceStatus(instance *appsv2beta1.EMQX, r
 		Username: r.GetUsername(),
 		Password: r.GetPassword(),
 	}
+	if req, ok := r.(*innerReq.Requester); ok {
+		requester.TLSConfig = req.TLSConfig
+	}
 
 	url := requester.GetURL("api/v5/load_rebalance/availability_check")
 	resp, _, err := requester.Request("GET", url, nil, nil)
@@ -0,0 +1,110 @@
+package v2beta1
+
+import (
+	"fmt"
+	"strings"
+
+	semver "github.com/Masterminds/semver/v3"
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+)
+
+const (
+	editionOpensource = "Opensource"
+	editionEnterprise = "Enterprise"
+)
+
+type upgradePath struct {
+	// Supported is false if the EMQX nodes can not be updated to the image
+	Supported bool
+	// Reason is empty if nothing is wrong with the transition
+	Reason  string
+	Message string
+}
+
+// checkUpgradePath checks the transition from the running EMQX core nodes to the image of the EMQX spec.
+// It returns nil if the transition can not be resolved, for example the image tag is not a version,
+// or there is no running EMQX core node.
+func checkUpgradePath(instance *appsv2beta1.EMQX) *upgradePath {
+	targetVersion, targetEdition := parseImageVersion(instance.Spec.Image)
+	if targetVersion == nil {
+		return nil
+	}
+	runningVersion, runningEdition := getRunningVersion(instance)
+	if runningVersion == nil {
+		return nil
+	}
+
+	transition := fmt.Sprintf("from %s to %s", formatEMQXVersion(runningEdition, runningVersion), formatEMQXVersion(targetEdition, targetVersion))
+	if targetEdition != "" && runningEdition != "" && targetEdition != runningEdition {
+		return &upgradePath{Reason: "EditionChange", Message: "can not update EMQX " + transition}
+	}
+	if targetVersion.Major() != runningVersion.Major() {
+		return &upgradePath{Reason: "MajorVersionChange", Message: "can not update EMQX " + transition}
+	}
+	if targetVersion.Minor() < runningVersion.Minor() {
+		// The data format of EMQX may change in every minor version
+		return &upgradePath{Reason: "DataFormatDowngrade", Message: "can not update EMQX " + transition + ", the data format can not be downgraded"}
+	}
+
+	path := &upgradePath{Supported: true, Message: "update EMQX " + transition}
+	if targetVersion.Minor() == runningVersion.Minor() && targetVersion.Patch() < runningVersion.Patch() {
+		path = &upgradePath{Supported: true, Reason: "PatchDowngrade", Message: "downgrade EMQX " + transition}
+	}
+	if targetEdition == "" || runningEdition == "" {
+		path.Message += ", the edition change is not checked because the EMQX edition is unknown"
+	}
+	return path
+}
+
+// formatEMQXVersion returns the edition and the version of EMQX, or only the version if the edition is unknown.
+func formatEMQXVersion(edition string, version *semver.Version) string {
+	if edition == "" {
+		return version.Original()
+	}
+	return edition + " " + version.Original()
+}
+
+// getRunningVersion returns the lowest version of the running EMQX core nodes, and the edition of them.
+func getRunningVersion(instance *appsv2beta1.EMQX) (*semver.Version, string) {
+	var version *semver.Version
+	var edition string
+	for _, node := range instance.Status.CoreNodes {
+		v, err := semver.NewVersion(node.Version)
+		if err != nil {
+			continue
+		}
+		if version == nil || v.LessThan(version) {
+			version = v
+		}
+		edition = node.Edition
+	}
+	return version, edition
+}
+
+// parseImageVersion returns the version and the edition of the EMQX image, like "emqx/emqx-enterprise:5.8.0".
+// The edition is empty if it can not be told from the image.
+func parseImageVersion(image string) (*semver.Version, string) {
+	image, _, _ = strings.Cut(image, "@")
+	index := strings.LastIndex(image, ":")
+	if index == -1 || strings.Contains(image[index:], "/") {
+		return nil, ""
+	}
+	repository, tag := image[:index], image[index+1:]
+
+	version, err := semver.NewVersion(tag)
+	if err != nil {
+		return nil, ""
+	}
+
+	var edition string
+	switch repository[strings.LastIndex(repository, "/")+1:] {
+	case "emqx-enterprise":
+		edition = editionEnterprise
+	case "emqx":
+		// Since EMQX 5.9, the emqx image is the enterprise edition
+		if version.LessThan(semver.MustParse("5.9.0")) {
+			edition = editionOpensource
+		}
+	}
+	return version, edition
+}
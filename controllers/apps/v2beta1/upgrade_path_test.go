@@ -0,0 +1,116 @@
+package v2beta1
+
+import (
+	"context"
+	"testing"
+
+	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
+	"github.com/emqx/emqx-operator/internal/handler"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseImageVersion(t *testing.T) {
+	for _, tc := range []struct {
+		image   string
+		version string
+		edition string
+	}{
+		{"emqx/emqx:5.8.0", "5.8.0", editionOpensource},
+		{"emqx:5.1", "5.1", editionOpensource},
+		{"emqx/emqx:5.9.0", "5.9.0", ""},
+		{"emqx/emqx-enterprise:5.8.0", "5.8.0", editionEnterprise},
+		{"localhost:5000/emqx/emqx-enterprise:5.8.0@sha256:abc", "5.8.0", editionEnterprise},
+		{"localhost:5000/emqx/emqx", "", ""},
+		{"emqx/emqx:latest", "", ""},
+		{"emqx/emqx", "", ""},
+	} {
+		version, edition := parseImageVersion(tc.image)
+		if tc.version == "" {
+			assert.Nil(t, version, tc.image)
+			continue
+		}
+		assert.Equal(t, tc.version, version.Original(), tc.image)
+		assert.Equal(t, tc.edition, edition, tc.image)
+	}
+}
+
+func TestCheckUpgradePath(t *testing.T) {
+	instance := &appsv2beta1.EMQX{}
+	instance.Spec.Image = "emqx/emqx:5.8.0"
+	assert.Nil(t, checkUpgradePath(instance))
+
+	instance.Status.CoreNodes = []appsv2beta1.EMQXNode{
+		{Version: "5.7.2", Edition: editionOpensource},
+		{Version: "5.8.0", Edition: editionOpensource},
+	}
+
+	for _, tc := range []struct {
+		image     string
+		supported bool
+		reason    string
+	}{
+		{"emqx/emqx:5.8.0", true, ""},
+		{"emqx/emqx:5.7.2", true, ""},
+		{"emqx/emqx:5.7.1", true, "PatchDowngrade"},
+		{"emqx/emqx:5.6.0", false, "DataFormatDowngrade"},
+		{"emqx/emqx:6.0.0", false, "MajorVersionChange"},
+		{"emqx/emqx-enterprise:5.8.0", false, "EditionChange"},
+	} {
+		instance.Spec.Image = tc.image
+		got := checkUpgradePath(instance)
+		assert.Equal(t, tc.supported, got.Supported, tc.image)
+		assert.Equal(t, tc.reason, got.Reason, tc.image)
+	}
+
+	// The edition of the emqx image since EMQX 5.9 can not be told from the image
+	instance.Spec.Image = "emqx/emqx:5.9.0"
+	got := checkUpgradePath(instance)
+	assert.True(t, got.Supported)
+	assert.Equal(t, "update EMQX from Opensource 5.7.2 to 5.9.0, the edition change is not checked because the EMQX edition is unknown", got.Message)
+
+	instance.Spec.Image = "emqx/emqx:latest"
+	assert.Nil(t, checkUpgradePath(instance))
+}
+
+func TestAddCoreUnsupportedUpgradePath(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = appsv2beta1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{Name: "emqx", Namespace: "emqx"},
+		Spec: appsv2beta1.EMQXSpec{
+			Image: "emqx/emqx:6.0.0",
+			CoreTemplate: appsv2beta1.EMQXCoreTemplate{
+				Spec: appsv2beta1.EMQXCoreTemplateSpec{
+					EMQXReplicantTemplateSpec: appsv2beta1.EMQXReplicantTemplateSpec{Replicas: ptr.To(int32(1))},
+				},
+			},
+		},
+	}
+	instance.Status.CoreNodes = []appsv2beta1.EMQXNode{{Version: "5.8.0", Edition: editionOpensource}}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).WithStatusSubresource(instance).Build()
+	a := &addCore{&EMQXReconciler{
+		Handler:       &handler.Handler{Client: k8sClient},
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(10),
+	}}
+
+	// The rest of the sub reconcilers are not blocked, only the new statefulSet is not created
+	result := a.reconcile(context.Background(), logr.Discard(), instance, nil)
+	assert.Nil(t, result.err)
+	assert.True(t, result.result.IsZero())
+	_, condition := instance.Status.GetCondition(appsv2beta1.UpgradePathSupported)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	stsList := &appsv1.StatefulSetList{}
+	assert.Nil(t, k8sClient.List(context.Background(), stsList))
+	assert.Empty(t, stsList.Items)
+}
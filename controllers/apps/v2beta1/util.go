@@ -19,8 +19,10 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -81,6 +83,11 @@ func getStateFulSetList(ctx context.Context, k8sClient client.Client, instance *
 		}
 	}
 
+	// In the in-place update mode, the same statefulSet is both the update and the current statefulSet until all the pods are updated
+	if currentSts == nil && updateSts != nil && updateSts.Name == instance.CoreNamespacedName().Name {
+		currentSts = updateSts
+	}
+
 	sort.Sort(StatefulSetsByCreationTimestamp(oldStsList))
 	return
 }
@@ -111,6 +118,56 @@ func getReplicaSetList(ctx context.Context, k8sClient client.Client, instance *a
 	return
 }
 
+// getRevisions returns the revisions of the retained statefulSets and replicaSets, sorted by creation time.
+func getRevisions(ctx context.Context, k8sClient client.Client, instance *appsv2beta1.EMQX) []appsv2beta1.EMQXRevision {
+	revisions := []appsv2beta1.EMQXRevision{}
+
+	stsList := &appsv1.StatefulSetList{}
+	_ = k8sClient.List(ctx, stsList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultCoreLabels(instance)),
+	)
+	for _, sts := range stsList.Items {
+		if hash, ok := sts.Labels[appsv2beta1.LabelsPodTemplateHashKey]; ok {
+			revisions = append(revisions, generateRevision(&sts.ObjectMeta, &sts.Spec.Template, sts.Spec.Replicas, "core", hash))
+		}
+	}
+
+	rsList := &appsv1.ReplicaSetList{}
+	_ = k8sClient.List(ctx, rsList,
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels(appsv2beta1.DefaultReplicantLabels(instance)),
+	)
+	for _, rs := range rsList.Items {
+		if hash, ok := rs.Labels[appsv2beta1.LabelsPodTemplateHashKey]; ok {
+			revisions = append(revisions, generateRevision(&rs.ObjectMeta, &rs.Spec.Template, rs.Spec.Replicas, "replicant", hash))
+		}
+	}
+
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].CreationTime.Before(&revisions[j].CreationTime)
+	})
+	return revisions
+}
+
+func generateRevision(meta *metav1.ObjectMeta, template *corev1.PodTemplateSpec, replicas *int32, role, hash string) appsv2beta1.EMQXRevision {
+	revision := appsv2beta1.EMQXRevision{
+		Role:         role,
+		Revision:     hash,
+		ConfigHash:   meta.Annotations[appsv2beta1.AnnotationsRevisionConfigHashKey],
+		CreationTime: meta.CreationTimestamp,
+	}
+	if replicas != nil {
+		revision.Replicas = *replicas
+	}
+	for _, container := range template.Spec.Containers {
+		if container.Name == appsv2beta1.DefaultContainerName {
+			revision.Image = container.Image
+		}
+	}
+	return revision
+}
+
 func getEventList(ctx context.Context, clientSet *kubernetes.Clientset, obj client.Object) []*corev1.Event {
 	// https://github.com/kubernetes-sigs/kubebuilder/issues/547#issuecomment-450772300
 	eventList, _ := clientSet.CoreV1().Events(obj.GetNamespace()).List(ctx, metav1.ListOptions{
@@ -139,6 +196,164 @@ func checkInitialDelaySecondsReady(instance *appsv2beta1.EMQX) bool {
 	return int32(delay) > instance.Spec.UpdateStrategy.InitialDelaySeconds
 }
 
+func isRollingUpdate(instance *appsv2beta1.EMQX) bool {
+	return instance.Spec.UpdateStrategy.Type == appsv2beta1.UpdateStrategyRollingUpdate
+}
+
+// resolveRollingUpdateFenceposts returns the absolute maxSurge and maxUnavailable of EMQX replicant nodes.
+// Like the Deployment controller, maxSurge is rounded up, maxUnavailable is rounded down,
+// and both of them can not be 0 at the same time.
+func resolveRollingUpdateFenceposts(instance *appsv2beta1.EMQX) (maxSurge, maxUnavailable int32) {
+	desired := int(*instance.Spec.ReplicantTemplate.Spec.Replicas)
+
+	surge, unavailable := intstr.FromString("25%"), intstr.FromInt(0)
+	if rollingUpdate := instance.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil {
+		if rollingUpdate.MaxSurge != nil {
+			surge = *rollingUpdate.MaxSurge
+		}
+		if rollingUpdate.MaxUnavailable != nil {
+			unavailable = *rollingUpdate.MaxUnavailable
+		}
+	}
+
+	s, _ := intstr.GetScaledValueFromIntOrPercent(&surge, desired, true)
+	u, _ := intstr.GetScaledValueFromIntOrPercent(&unavailable, desired, false)
+	if s == 0 && u == 0 {
+		u = 1
+	}
+	return int32(s), int32(u)
+}
+
+// getRollingUpdateReplicas returns the replicas of the update replicaSet,
+// so that the sum of the update and current replicaSet never exceeds desired replicas + maxSurge.
+// At least one EMQX replicant node of the new revision is started, so the old nodes always have an evacuation target.
+func getRollingUpdateReplicas(instance *appsv2beta1.EMQX, currentReplicas int32) int32 {
+	desired := *instance.Spec.ReplicantTemplate.Spec.Replicas
+	maxSurge, _ := resolveRollingUpdateFenceposts(instance)
+
+	replicas := desired + maxSurge - currentReplicas
+	if replicas > desired {
+		replicas = desired
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+	return replicas
+}
+
+// checkRollingUpdateMaxUnavailable returns true if one more EMQX replicant node of the current replicaSet can be removed
+// without the number of running replicant nodes going below desired replicas - maxUnavailable.
+// Only the running nodes of the update and current replicaSet are counted, the nodes of the retained replicaSets are not.
+func checkRollingUpdateMaxUnavailable(instance *appsv2beta1.EMQX, updateRs, currentRs *appsv1.ReplicaSet) bool {
+	desired := *instance.Spec.ReplicantTemplate.Spec.Replicas
+	_, maxUnavailable := resolveRollingUpdateFenceposts(instance)
+
+	var available int32
+	for _, node := range instance.Status.ReplicantNodes {
+		if node.NodeStatus != "running" {
+			continue
+		}
+		if (updateRs != nil && node.ControllerUID == updateRs.UID) || (currentRs != nil && node.ControllerUID == currentRs.UID) {
+			available++
+		}
+	}
+	return available-1 >= desired-maxUnavailable
+}
+
+// isRollingUpdateStep returns true if the EMQX replicant nodes are in the middle of a rolling update,
+// and the Available condition has been set for this rollout.
+func isRollingUpdateStep(instance *appsv2beta1.EMQX, updateRs, currentRs *appsv1.ReplicaSet) bool {
+	return isRollingUpdate(instance) &&
+		appsv2beta1.IsExistReplicant(instance) &&
+		updateRs != nil && currentRs != nil && updateRs.UID != currentRs.UID &&
+		updateRs.Labels[appsv2beta1.LabelsPodTemplateHashKey] == instance.Status.ReplicantNodesStatus.UpdateRevision &&
+		instance.Status.IsConditionTrue(appsv2beta1.Available)
+}
+
+func isInPlaceUpdate(instance *appsv2beta1.EMQX) bool {
+	return instance.Spec.UpdateStrategy.CoreUpdateMode == appsv2beta1.CoreUpdateModeInPlace
+}
+
+func getStatefulSetPartition(sts *appsv1.StatefulSet) int32 {
+	if sts.Spec.UpdateStrategy.RollingUpdate == nil || sts.Spec.UpdateStrategy.RollingUpdate.Partition == nil {
+		return 0
+	}
+	return *sts.Spec.UpdateStrategy.RollingUpdate.Partition
+}
+
+// checkInPlaceUpdateCompleted returns true if all the pods of the statefulSet have been updated to the latest pod template.
+func checkInPlaceUpdateCompleted(sts *appsv1.StatefulSet) bool {
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		getStatefulSetPartition(sts) == 0 &&
+		sts.Status.UpdateRevision == sts.Status.CurrentRevision &&
+		sts.Status.UpdatedReplicas == *sts.Spec.Replicas
+}
+
+// checkInPlacePartitionReady returns true if the pods from the partition ordinal of the statefulSet are updated,
+// and the EMQX nodes of them are running, so the partition can move to the next ordinal.
+func checkInPlacePartitionReady(instance *appsv2beta1.EMQX, sts *appsv1.StatefulSet, pods []corev1.Pod) bool {
+	for ordinal := getStatefulSetPartition(sts); ordinal < *sts.Spec.Replicas; ordinal++ {
+		name := fmt.Sprintf("%s-%d", sts.Name, ordinal)
+		var pod *corev1.Pod
+		for i := range pods {
+			if pods[i].Name == name {
+				pod = &pods[i]
+				break
+			}
+		}
+		if pod == nil || pod.DeletionTimestamp != nil || pod.Labels[appsv2beta1.LabelsPodTemplateHashKey] != sts.Labels[appsv2beta1.LabelsPodTemplateHashKey] {
+			return false
+		}
+
+		running := false
+		for _, node := range instance.Status.CoreNodes {
+			if node.PodUID == pod.UID && node.NodeStatus == "running" {
+				running = true
+				break
+			}
+		}
+		if !running {
+			return false
+		}
+	}
+	return true
+}
+
+func isCanaryUpdate(instance *appsv2beta1.EMQX) bool {
+	return instance.Spec.UpdateStrategy.Canary != nil && len(instance.Spec.UpdateStrategy.Canary.Steps) > 0
+}
+
+// nextCanaryStatus returns the canary status after the given number of old EMQX nodes have been removed,
+// and whether the rest of the old EMQX nodes should be held at the current canary step.
+func nextCanaryStatus(instance *appsv2beta1.EMQX, revision string, desired, removed int32) (*appsv2beta1.CanaryStatus, bool) {
+	steps := instance.Spec.UpdateStrategy.Canary.Steps
+
+	status := &appsv2beta1.CanaryStatus{Revision: revision}
+	if instance.Status.CanaryStatus != nil && instance.Status.CanaryStatus.Revision == revision {
+		status = instance.Status.CanaryStatus.DeepCopy()
+	}
+	if int(status.CurrentStepIndex) >= len(steps) {
+		return status, false
+	}
+
+	step := steps[status.CurrentStepIndex]
+	target, _ := intstr.GetScaledValueFromIntOrPercent(&step.Replicas, int(desired), true)
+	if int(removed) < target {
+		return status, false
+	}
+
+	if status.CurrentStepReachedTime == nil {
+		status.CurrentStepReachedTime = ptr.To(metav1.Now())
+		return status, true
+	}
+	if time.Since(status.CurrentStepReachedTime.Time) < time.Duration(step.PauseSeconds)*time.Second {
+		return status, true
+	}
+	status.CurrentStepIndex++
+	status.CurrentStepReachedTime = nil
+	return status, true
+}
+
 func checkWaitTakeoverReady(instance *appsv2beta1.EMQX, eList []*corev1.Event) bool {
 	if len(eList) == 0 {
 		return true
@@ -149,6 +364,48 @@ func checkWaitTakeoverReady(instance *appsv2beta1.EMQX, eList []*corev1.Event) b
 	return int32(delay) > instance.Spec.UpdateStrategy.EvacuationStrategy.WaitTakeover
 }
 
+// generateRevisionSpec returns the part of EMQX spec which generates the pod templates of the StatefulSet and ReplicaSet.
+func generateRevisionSpec(instance *appsv2beta1.EMQX) string {
+	spec := appsv2beta1.EMQXSpec{
+		Image:              instance.Spec.Image,
+		ImagePullPolicy:    instance.Spec.ImagePullPolicy,
+		ImagePullSecrets:   instance.Spec.ImagePullSecrets,
+		ServiceAccountName: instance.Spec.ServiceAccountName,
+		ClusterDomain:      instance.Spec.ClusterDomain,
+		CoreTemplate:       instance.Spec.CoreTemplate,
+		ReplicantTemplate:  instance.Spec.ReplicantTemplate,
+	}
+	b, _ := json.Marshal(spec)
+	return string(b)
+}
+
+// restoreRevisionSpec restores EMQX spec from the revision spec annotation of the StatefulSet or ReplicaSet.
+func restoreRevisionSpec(instance *appsv2beta1.EMQX, obj client.Object) error {
+	data, ok := obj.GetAnnotations()[appsv2beta1.AnnotationsRevisionSpecKey]
+	if !ok {
+		return emperror.Errorf("%s does not have the %s annotation", obj.GetName(), appsv2beta1.AnnotationsRevisionSpecKey)
+	}
+	spec := &appsv2beta1.EMQXSpec{}
+	if err := json.Unmarshal([]byte(data), spec); err != nil {
+		return emperror.Wrap(err, "failed to unmarshal revision spec")
+	}
+	instance.Spec.Image = spec.Image
+	instance.Spec.ImagePullPolicy = spec.ImagePullPolicy
+	instance.Spec.ImagePullSecrets = spec.ImagePullSecrets
+	instance.Spec.ServiceAccountName = spec.ServiceAccountName
+	instance.Spec.ClusterDomain = spec.ClusterDomain
+	instance.Spec.CoreTemplate = spec.CoreTemplate
+	instance.Spec.ReplicantTemplate = spec.ReplicantTemplate
+	return nil
+}
+
+// computeStringHash returns a hash value calculated from the string, e.g. the EMQX config.
+func computeStringHash(str string) string {
+	hasher := fnv.New32a()
+	_, _ = hasher.Write([]byte(str))
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
 // JustCheckPodTemplate will check only the differences between the podTemplate of the two statefulSets
 func justCheckPodTemplate() patch.CalculateOption {
 	getPodTemplate := func(obj []byte) ([]byte, error) {
@@ -1,13 +1,20 @@
 package v2beta1
 
 import (
+	"context"
+	"strconv"
 	"testing"
 	"time"
 
 	appsv2beta1 "github.com/emqx/emqx-operator/apis/apps/v2beta1"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestCheckInitialDelaySecondsReady(t *testing.T) {
@@ -96,6 +103,416 @@ func TestCheckWaitTakeoverReady(t *testing.T) {
 	})
 }
 
+func TestGetRollingUpdateReplicas(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		Spec: appsv2beta1.EMQXSpec{
+			UpdateStrategy: appsv2beta1.UpdateStrategy{
+				Type: appsv2beta1.UpdateStrategyRollingUpdate,
+			},
+			ReplicantTemplate: &appsv2beta1.EMQXReplicantTemplate{
+				Spec: appsv2beta1.EMQXReplicantTemplateSpec{
+					Replicas: ptr.To(int32(10)),
+				},
+			},
+		},
+	}
+
+	t.Run("default maxSurge", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		assert.Equal(t, int32(3), getRollingUpdateReplicas(emqx, 10))
+		assert.Equal(t, int32(8), getRollingUpdateReplicas(emqx, 5))
+		assert.Equal(t, int32(10), getRollingUpdateReplicas(emqx, 0))
+	})
+
+	t.Run("absolute maxSurge", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.UpdateStrategy.RollingUpdate = &appsv2beta1.RollingUpdateStrategy{
+			MaxSurge: ptr.To(intstr.FromInt(5)),
+		}
+		assert.Equal(t, int32(5), getRollingUpdateReplicas(emqx, 10))
+		assert.Equal(t, int32(10), getRollingUpdateReplicas(emqx, 2))
+	})
+
+	t.Run("zero maxSurge still start one node", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.UpdateStrategy.RollingUpdate = &appsv2beta1.RollingUpdateStrategy{
+			MaxSurge:       ptr.To(intstr.FromInt(0)),
+			MaxUnavailable: ptr.To(intstr.FromInt(1)),
+		}
+		assert.Equal(t, int32(1), getRollingUpdateReplicas(emqx, 10))
+		assert.Equal(t, int32(2), getRollingUpdateReplicas(emqx, 8))
+	})
+}
+
+func TestCheckRollingUpdateMaxUnavailable(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		Spec: appsv2beta1.EMQXSpec{
+			UpdateStrategy: appsv2beta1.UpdateStrategy{
+				Type: appsv2beta1.UpdateStrategyRollingUpdate,
+			},
+			ReplicantTemplate: &appsv2beta1.EMQXReplicantTemplate{
+				Spec: appsv2beta1.EMQXReplicantTemplateSpec{
+					Replicas: ptr.To(int32(4)),
+				},
+			},
+		},
+	}
+	updateRs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{UID: "update"}}
+	currentRs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{UID: "current"}}
+	setNodes := func(emqx *appsv2beta1.EMQX, update, current, retained int) {
+		emqx.Status.ReplicantNodes = nil
+		for uid, count := range map[types.UID]int{"update": update, "current": current, "retained": retained} {
+			for i := 0; i < count; i++ {
+				emqx.Status.ReplicantNodes = append(emqx.Status.ReplicantNodes, appsv2beta1.EMQXNode{
+					ControllerUID: uid,
+					NodeStatus:    "running",
+				})
+			}
+		}
+	}
+
+	t.Run("default maxUnavailable", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		setNodes(emqx, 1, 4, 0)
+		assert.True(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+		setNodes(emqx, 0, 4, 0)
+		assert.False(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+	})
+
+	t.Run("nodes of retained replicaSet are not counted", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		setNodes(emqx, 0, 4, 1)
+		assert.False(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+	})
+
+	t.Run("nodes not running are not counted", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		setNodes(emqx, 1, 4, 0)
+		emqx.Status.ReplicantNodes[0].NodeStatus = "stopped"
+		assert.False(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+	})
+
+	t.Run("percent maxUnavailable", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.UpdateStrategy.RollingUpdate = &appsv2beta1.RollingUpdateStrategy{
+			MaxUnavailable: ptr.To(intstr.FromString("50%")),
+		}
+		setNodes(emqx, 0, 3, 0)
+		assert.True(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+		setNodes(emqx, 0, 2, 0)
+		assert.False(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+	})
+
+	t.Run("both zero means maxUnavailable is 1", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.UpdateStrategy.RollingUpdate = &appsv2beta1.RollingUpdateStrategy{
+			MaxSurge:       ptr.To(intstr.FromInt(0)),
+			MaxUnavailable: ptr.To(intstr.FromInt(0)),
+		}
+		setNodes(emqx, 0, 4, 0)
+		assert.True(t, checkRollingUpdateMaxUnavailable(emqx, updateRs, currentRs))
+	})
+}
+
+func TestIsRollingUpdateStep(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		Spec: appsv2beta1.EMQXSpec{
+			UpdateStrategy: appsv2beta1.UpdateStrategy{
+				Type: appsv2beta1.UpdateStrategyRollingUpdate,
+			},
+			ReplicantTemplate: &appsv2beta1.EMQXReplicantTemplate{
+				Spec: appsv2beta1.EMQXReplicantTemplateSpec{
+					Replicas: ptr.To(int32(4)),
+				},
+			},
+		},
+	}
+	instance.Status.ReplicantNodesStatus.UpdateRevision = "update"
+	instance.Status.SetCondition(metav1.Condition{Type: appsv2beta1.Available, Status: metav1.ConditionTrue})
+	updateRs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		UID:    "update",
+		Labels: map[string]string{appsv2beta1.LabelsPodTemplateHashKey: "update"},
+	}}
+	currentRs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{UID: "current"}}
+
+	assert.True(t, isRollingUpdateStep(instance, updateRs, currentRs))
+	// The rollout is finished
+	assert.False(t, isRollingUpdateStep(instance, updateRs, updateRs))
+
+	// A new rollout is started
+	emqx := instance.DeepCopy()
+	emqx.Status.ReplicantNodesStatus.UpdateRevision = "previous"
+	assert.False(t, isRollingUpdateStep(emqx, updateRs, currentRs))
+
+	// The cluster is not available yet
+	emqx = instance.DeepCopy()
+	emqx.Status.RemoveCondition(appsv2beta1.Available)
+	assert.False(t, isRollingUpdateStep(emqx, updateRs, currentRs))
+
+	emqx = instance.DeepCopy()
+	emqx.Spec.UpdateStrategy.Type = appsv2beta1.UpdateStrategyRecreate
+	assert.False(t, isRollingUpdateStep(emqx, updateRs, currentRs))
+}
+
+func TestCheckInPlacePartitionReady(t *testing.T) {
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "emqx-core",
+			Labels: map[string]string{appsv2beta1.LabelsPodTemplateHashKey: "new"},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: ptr.To(int32(3)),
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+					Partition: ptr.To(int32(2)),
+				},
+			},
+		},
+	}
+	newPod := func(ordinal int, hash string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "emqx-core-" + strconv.Itoa(ordinal),
+				UID:    types.UID("pod-" + strconv.Itoa(ordinal)),
+				Labels: map[string]string{appsv2beta1.LabelsPodTemplateHashKey: hash},
+			},
+		}
+	}
+	instance := &appsv2beta1.EMQX{}
+	instance.Status.CoreNodes = []appsv2beta1.EMQXNode{
+		{PodUID: "pod-0", NodeStatus: "running"},
+		{PodUID: "pod-1", NodeStatus: "running"},
+		{PodUID: "pod-2", NodeStatus: "running"},
+	}
+
+	t.Run("pod is updated and running", func(t *testing.T) {
+		pods := []corev1.Pod{newPod(0, "old"), newPod(1, "old"), newPod(2, "new")}
+		assert.True(t, checkInPlacePartitionReady(instance, sts, pods))
+	})
+
+	t.Run("pod is not updated", func(t *testing.T) {
+		pods := []corev1.Pod{newPod(0, "old"), newPod(1, "old"), newPod(2, "old")}
+		assert.False(t, checkInPlacePartitionReady(instance, sts, pods))
+	})
+
+	t.Run("pod is not found", func(t *testing.T) {
+		pods := []corev1.Pod{newPod(0, "old"), newPod(1, "old")}
+		assert.False(t, checkInPlacePartitionReady(instance, sts, pods))
+	})
+
+	t.Run("node is not running", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Status.CoreNodes[2].NodeStatus = "stopped"
+		pods := []corev1.Pod{newPod(0, "old"), newPod(1, "old"), newPod(2, "new")}
+		assert.False(t, checkInPlacePartitionReady(emqx, sts, pods))
+	})
+
+	t.Run("the last updated node has not rejoined the cluster", func(t *testing.T) {
+		got := sts.DeepCopy()
+		got.Spec.UpdateStrategy.RollingUpdate.Partition = ptr.To(int32(0))
+		pods := []corev1.Pod{newPod(0, "new"), newPod(1, "new"), newPod(2, "new")}
+		assert.True(t, checkInPlacePartitionReady(instance, got, pods))
+
+		emqx := instance.DeepCopy()
+		emqx.Status.CoreNodes = emqx.Status.CoreNodes[1:]
+		assert.False(t, checkInPlacePartitionReady(emqx, got, pods))
+	})
+}
+
+func TestCheckInPlaceUpdateCompleted(t *testing.T) {
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: ptr.To(int32(3)),
+		},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 2,
+			CurrentRevision:    "emqx-core-new",
+			UpdateRevision:     "emqx-core-new",
+			UpdatedReplicas:    3,
+		},
+	}
+	assert.True(t, checkInPlaceUpdateCompleted(sts))
+
+	got := sts.DeepCopy()
+	got.Generation = 3
+	assert.False(t, checkInPlaceUpdateCompleted(got))
+
+	got = sts.DeepCopy()
+	got.Status.CurrentRevision = "emqx-core-old"
+	got.Status.UpdatedReplicas = 1
+	assert.False(t, checkInPlaceUpdateCompleted(got))
+
+	got = sts.DeepCopy()
+	got.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: ptr.To(int32(1))}
+	assert.False(t, checkInPlaceUpdateCompleted(got))
+}
+
+func TestNextCanaryStatus(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		Spec: appsv2beta1.EMQXSpec{
+			UpdateStrategy: appsv2beta1.UpdateStrategy{
+				Canary: &appsv2beta1.CanaryStrategy{
+					Steps: []appsv2beta1.CanaryStep{
+						{Replicas: intstr.FromString("10%"), PauseSeconds: 600},
+						{Replicas: intstr.FromString("50%"), PauseSeconds: 0},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("new revision, step not reached", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Status.CanaryStatus = &appsv2beta1.CanaryStatus{Revision: "old", CurrentStepIndex: 2}
+		got, hold := nextCanaryStatus(emqx, "new", 10, 0)
+		assert.False(t, hold)
+		assert.Equal(t, &appsv2beta1.CanaryStatus{Revision: "new"}, got)
+	})
+
+	t.Run("step reached, start pause", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		got, hold := nextCanaryStatus(emqx, "new", 10, 1)
+		assert.True(t, hold)
+		assert.Equal(t, int32(0), got.CurrentStepIndex)
+		assert.NotNil(t, got.CurrentStepReachedTime)
+	})
+
+	t.Run("step reached, in pause", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Status.CanaryStatus = &appsv2beta1.CanaryStatus{
+			Revision:               "new",
+			CurrentStepReachedTime: &metav1.Time{Time: time.Now()},
+		}
+		got, hold := nextCanaryStatus(emqx, "new", 10, 1)
+		assert.True(t, hold)
+		assert.Equal(t, int32(0), got.CurrentStepIndex)
+	})
+
+	t.Run("step reached, pause finished", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Status.CanaryStatus = &appsv2beta1.CanaryStatus{
+			Revision:               "new",
+			CurrentStepReachedTime: &metav1.Time{Time: time.Now().AddDate(0, 0, -1)},
+		}
+		got, hold := nextCanaryStatus(emqx, "new", 10, 1)
+		assert.True(t, hold)
+		assert.Equal(t, &appsv2beta1.CanaryStatus{Revision: "new", CurrentStepIndex: 1}, got)
+
+		emqx.Status.CanaryStatus = got
+		got, hold = nextCanaryStatus(emqx, "new", 10, 1)
+		assert.False(t, hold)
+		assert.Equal(t, int32(1), got.CurrentStepIndex)
+	})
+
+	t.Run("all steps finished", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Status.CanaryStatus = &appsv2beta1.CanaryStatus{Revision: "new", CurrentStepIndex: 2}
+		_, hold := nextCanaryStatus(emqx, "new", 10, 9)
+		assert.False(t, hold)
+	})
+}
+
+func TestRestoreRevisionSpec(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		Spec: appsv2beta1.EMQXSpec{
+			Image: "emqx/emqx:5.1",
+			CoreTemplate: appsv2beta1.EMQXCoreTemplate{
+				Spec: appsv2beta1.EMQXCoreTemplateSpec{
+					EMQXReplicantTemplateSpec: appsv2beta1.EMQXReplicantTemplateSpec{
+						Replicas: ptr.To(int32(3)),
+					},
+				},
+			},
+		},
+	}
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "emqx-core",
+			Annotations: map[string]string{
+				appsv2beta1.AnnotationsRevisionSpecKey: generateRevisionSpec(instance),
+			},
+		},
+	}
+
+	t.Run("restore spec", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		emqx.Spec.Image = "emqx/emqx:5.2"
+		emqx.Spec.CoreTemplate.Spec.Replicas = ptr.To(int32(5))
+		emqx.Spec.RevisionHistoryLimit = ptr.To(int32(10))
+		assert.NoError(t, restoreRevisionSpec(emqx, sts))
+		assert.Equal(t, "emqx/emqx:5.1", emqx.Spec.Image)
+		assert.Equal(t, ptr.To(int32(3)), emqx.Spec.CoreTemplate.Spec.Replicas)
+		assert.Equal(t, ptr.To(int32(10)), emqx.Spec.RevisionHistoryLimit)
+	})
+
+	t.Run("missing annotation", func(t *testing.T) {
+		emqx := instance.DeepCopy()
+		assert.Error(t, restoreRevisionSpec(emqx, &appsv1.StatefulSet{}))
+	})
+}
+
+func TestGetRevisions(t *testing.T) {
+	instance := &appsv2beta1.EMQX{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "emqx",
+			Namespace: "emqx",
+		},
+	}
+	newContainers := func(image string) []corev1.Container {
+		return []corev1.Container{{Name: appsv2beta1.DefaultContainerName, Image: image}}
+	}
+
+	oldSts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "emqx-core-old",
+			Namespace:         "emqx",
+			Labels:            appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultCoreLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "old"),
+			Annotations:       map[string]string{appsv2beta1.AnnotationsRevisionConfigHashKey: "fake"},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: ptr.To(int32(0)),
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: newContainers("emqx/emqx:5.1")}},
+		},
+	}
+	newSts := oldSts.DeepCopy()
+	newSts.Name = "emqx-core-new"
+	newSts.Labels = appsv2beta1.CloneAndAddLabel(newSts.Labels, appsv2beta1.LabelsPodTemplateHashKey, "new")
+	newSts.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	newSts.Spec.Replicas = ptr.To(int32(3))
+	newSts.Spec.Template.Spec.Containers = newContainers("emqx/emqx:5.2")
+
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "emqx-replicant-new",
+			Namespace:         "emqx",
+			Labels:            appsv2beta1.CloneAndAddLabel(appsv2beta1.DefaultReplicantLabels(instance), appsv2beta1.LabelsPodTemplateHashKey, "repl"),
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: ptr.To(int32(2)),
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: newContainers("emqx/emqx:5.2")}},
+		},
+	}
+
+	k8sClient := fake.NewClientBuilder().WithObjects(newSts, rs, oldSts).Build()
+	got := getRevisions(context.Background(), k8sClient, instance)
+	assert.Len(t, got, 3)
+	assert.Equal(t, "core", got[0].Role)
+	assert.Equal(t, "old", got[0].Revision)
+	assert.Equal(t, "emqx/emqx:5.1", got[0].Image)
+	assert.Equal(t, "fake", got[0].ConfigHash)
+	assert.Equal(t, int32(0), got[0].Replicas)
+	assert.Equal(t, "new", got[1].Revision)
+	assert.Equal(t, int32(3), got[1].Replicas)
+	assert.Equal(t, "replicant", got[2].Role)
+	assert.Equal(t, "repl", got[2].Revision)
+	assert.Equal(t, "emqx/emqx:5.2", got[2].Image)
+}
+
 func TestHandlerEventList(t *testing.T) {
 	t.Run("filter event", func(t *testing.T) {
 		list := &corev1.EventList{
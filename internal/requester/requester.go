@@ -2,9 +2,11 @@ package requester
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	emperror "emperror.dev/errors"
@@ -28,6 +30,8 @@ type Requester struct {
 	Host     string
 	Username string
 	Password string
+	// TLSConfig is used for the https schema, the default TLS config is used if it's nil
+	TLSConfig *tls.Config
 }
 
 func (requester *Requester) GetUsername() string {
@@ -65,12 +69,45 @@ func (requester *Requester) GetURL(path string, query ...string) url.URL {
 	return url
 }
 
+const requestTimeout = 30 * time.Second
+
 var httpClient = &http.Client{
 	Transport: &http.Transport{
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
 	},
+	Timeout: requestTimeout,
+}
+
+// tlsHTTPClients caches the HTTP clients by the TLS configs, so the connections are reused by the requests with the same TLS config
+var tlsHTTPClients sync.Map
+
+func (requester *Requester) getHTTPClient() *http.Client {
+	if requester.TLSConfig == nil {
+		return httpClient
+	}
+	if client, ok := tlsHTTPClients.Load(requester.TLSConfig); ok {
+		return client.(*http.Client)
+	}
+	client, _ := tlsHTTPClients.LoadOrStore(requester.TLSConfig, &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConns:       10,
+			IdleConnTimeout:    30 * time.Second,
+			DisableCompression: true,
+			TLSClientConfig:    requester.TLSConfig,
+		},
+		Timeout: requestTimeout,
+	})
+	return client.(*http.Client)
+}
+
+// ReleaseTLSConfig closes the idle connections of the HTTP client of the TLS config and removes it from the cache,
+// it should be called once the TLS config is no longer used.
+func ReleaseTLSConfig(tlsConfig *tls.Config) {
+	if client, ok := tlsHTTPClients.LoadAndDelete(tlsConfig); ok {
+		client.(*http.Client).CloseIdleConnections()
+	}
 }
 
 func (requester *Requester) Request(method string, url url.URL, body []byte, header http.Header) (resp *http.Response, respBody []byte, err error) {
@@ -99,7 +136,7 @@ func (requester *Requester) Request(method string, url url.URL, body []byte, hea
 		req.Header.Set("Accept", "application/json")
 	}
 
-	resp, err = httpClient.Do(req)
+	resp, err = requester.getHTTPClient().Do(req)
 	if err != nil {
 		return nil, nil, emperror.Wrap(err, "failed to request API")
 	}